@@ -0,0 +1,186 @@
+// functions to compute brightness and colour of an image, used to filter
+// posts by their (small) reddit preview before downloading
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+// pixels darker than this relative luminance are counted as black
+const blackLuminance = 0.04
+
+var colorNames = map[string]bool{
+	"black": true, "white": true, "gray": true, "red": true, "orange": true,
+	"yellow": true, "green": true, "cyan": true, "blue": true, "purple": true,
+	"pink": true,
+}
+
+type ColorStats struct {
+	// mean relative luminance, between 0 and 1
+	Luminance float64
+	// fraction of pixels which are (almost) black
+	BlackRatio    float64
+	DominantColor string
+	DominantHex   string
+}
+
+func relativeLuminance(r, g, b float64) float64 {
+	return 0.2126*r + 0.7152*g + 0.0722*b
+}
+
+// computeColorStats computes brightness and dominant colour of img.
+// Dominant colour is the average of most common bucket, when pixels
+// are grouped by top 3 bits of each channel.
+func computeColorStats(img image.Image) ColorStats {
+	type bucket struct {
+		count   int
+		r, g, b float64
+	}
+	var buckets [512]bucket
+	var sumLuminance float64
+	var black, total int
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r16, g16, b16, _ := img.At(x, y).RGBA()
+			r, g, b := float64(r16)/0xFFFF, float64(g16)/0xFFFF, float64(b16)/0xFFFF
+			luminance := relativeLuminance(r, g, b)
+			sumLuminance += luminance
+			if luminance < blackLuminance {
+				black++
+			}
+			total++
+			key := (r16>>13)<<6 | (g16>>13)<<3 | b16>>13
+			buckets[key].count++
+			buckets[key].r += r
+			buckets[key].g += g
+			buckets[key].b += b
+		}
+	}
+	if total == 0 {
+		return ColorStats{}
+	}
+
+	dominant := buckets[0]
+	for _, bkt := range buckets {
+		if bkt.count > dominant.count {
+			dominant = bkt
+		}
+	}
+	n := float64(dominant.count)
+	r, g, b := dominant.r/n, dominant.g/n, dominant.b/n
+	return ColorStats{
+		Luminance:     sumLuminance / float64(total),
+		BlackRatio:    float64(black) / float64(total),
+		DominantColor: colorName(r, g, b),
+		DominantHex: fmt.Sprintf("#%02x%02x%02x",
+			int(r*255+0.5), int(g*255+0.5), int(b*255+0.5)),
+	}
+}
+
+// colorName classifies a colour (channels between 0 and 1) into
+// one of colorNames, using its hue, saturation and lightness.
+func colorName(r, g, b float64) string {
+	maxC := math.Max(r, math.Max(g, b))
+	minC := math.Min(r, math.Min(g, b))
+	lightness := (maxC + minC) / 2
+	switch {
+	case lightness < 0.12:
+		return "black"
+	case lightness > 0.9:
+		return "white"
+	}
+	chroma := maxC - minC
+	saturation := chroma / (1 - math.Abs(2*lightness-1))
+	if saturation < 0.15 {
+		return "gray"
+	}
+
+	var hue float64
+	switch maxC {
+	case r:
+		hue = math.Mod((g-b)/chroma+6, 6)
+	case g:
+		hue = (b-r)/chroma + 2
+	default:
+		hue = (r-g)/chroma + 4
+	}
+	hue *= 60
+
+	switch {
+	case hue < 15:
+		return "red"
+	case hue < 45:
+		return "orange"
+	case hue < 70:
+		return "yellow"
+	case hue < 165:
+		return "green"
+	case hue < 195:
+		return "cyan"
+	case hue < 255:
+		return "blue"
+	case hue < 290:
+		return "purple"
+	case hue < 335:
+		return "pink"
+	}
+	return "red"
+}
+
+// colorFilterReason returns why the post should be skipped according to
+// colour filter options, or empty string if it passes all of them.
+func colorFilterReason(cs ColorStats) string {
+	if options.MaxMeanLuminance != -1 && cs.Luminance > options.MaxMeanLuminance {
+		return fmt.Sprintf("Mean luminance %.3f is too high", cs.Luminance)
+	}
+	if options.MinBlackRatio != -1 && cs.BlackRatio < options.MinBlackRatio {
+		return fmt.Sprintf("Black ratio %.3f is too low", cs.BlackRatio)
+	}
+	if len(options.DominantColors) > 0 {
+		for _, color := range options.DominantColors {
+			if color == cs.DominantColor {
+				return ""
+			}
+		}
+		return "Dominant color is " + cs.DominantColor
+	}
+	return ""
+}
+
+func hasColorFilters() bool {
+	return options.MaxMeanLuminance != -1 || options.MinBlackRatio != -1 ||
+		len(options.DominantColors) > 0
+}
+
+// fields of post data set from colour stats of preview
+var colorStatFields = []string{
+	"rrip_luminance", "rrip_black_ratio", "rrip_dominant_color", "rrip_dominant_color_hex",
+}
+
+// usesColorStats reports whether colour stats of preview should be
+// computed, either for filtering or because output fields or a
+// template refer to them.
+func usesColorStats(fields []string, templates ...string) bool {
+	if hasColorFilters() {
+		return true
+	}
+	for _, statField := range colorStatFields {
+		for _, field := range fields {
+			if field == statField {
+				return true
+			}
+		}
+		for _, tm := range templates {
+			if strings.Contains(tm, statField) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestUsesColorStats(t *testing.T) {
+	saved := options
+	t.Cleanup(func() { options = saved })
+	// no colour filters
+	options.MaxMeanLuminance, options.MinBlackRatio, options.DominantColors = -1, -1, nil
+
+	tests := []struct {
+		fields    []string
+		templates []string
+		want      bool
+	}{
+		{nil, []string{"{{.title}}"}, false},
+		{nil, []string{"{{.title}}", "{{.rrip_luminance}}"}, true},
+		{nil, []string{"{{.rrip_dominant_color_hex}}"}, true},
+		{[]string{"id", "title"}, nil, false},
+		{[]string{"id", "rrip_black_ratio"}, nil, true},
+		{[]string{"rrip_dominant_color_hex"}, []string{""}, true},
+	}
+	for _, tt := range tests {
+		if got := usesColorStats(tt.fields, tt.templates...); got != tt.want {
+			t.Errorf("usesColorStats(%q, %q) = %t, want %t", tt.fields, tt.templates, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// config file with default options and named profiles, and RRIP_*
+// environment variables. Options are applied in order config file,
+// environment, command line flags, each overriding the previous ones.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	flag "github.com/spf13/pflag"
+)
+
+const envPrefix = "RRIP_"
+
+// configFlags holds options which locate the config file
+type configFlags struct {
+	path, profile string
+}
+
+func addConfigFlags(flags *flag.FlagSet) *configFlags {
+	c := &configFlags{}
+	flags.StringVar(&c.path, "config", "",
+		"Config file with default options and profiles (default "+defaultConfigPath()+")")
+	flags.StringVar(&c.profile, "profile", "", "Use options of given profile in config file")
+	return c
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "rrip", "config.toml")
+}
+
+// envName returns environment variable for a flag, eg: RRIP_MIN_SCORE
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// setFlag sets value of a flag from a value in config file. Arrays and
+// tables are set one element at a time. The flag isn't marked as changed,
+// so that checks of options given on command line aren't affected.
+func setFlag(flags *flag.FlagSet, name string, value any) error {
+	f := flags.Lookup(name)
+	switch value := value.(type) {
+	case []any:
+		for _, item := range value {
+			if err := setFlag(flags, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]any:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := f.Value.Set(fmt.Sprintf("%s=%v", key, value[key])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return f.Value.Set(fmt.Sprint(value))
+}
+
+// applyConfigTable sets flags not set already (on command line, or in
+// set) from values in table. Keys are flag names, with either - or _ as
+// separator. Keys which aren't options of current command are skipped,
+// since config file is shared by all commands.
+func applyConfigTable(flags *flag.FlagSet, table map[string]any, set map[string]bool) error {
+	for key, value := range table {
+		name := strings.ReplaceAll(key, "_", "-")
+		if name == "profiles" || name == "sources" {
+			continue
+		}
+		if flags.Lookup(name) == nil {
+			log("Config option not used by this command:", quote(key))
+			continue
+		}
+		if flags.Changed(name) || set[name] {
+			continue
+		}
+		if err := setFlag(flags, name, value); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		set[name] = true
+	}
+	return nil
+}
+
+// apply sets flags which weren't given on command line, first from
+// RRIP_* environment variables, then from selected profile and top
+// level of config file. Must be called after parsing flags. Returns
+// sources listed in profile or config file.
+func (c *configFlags) apply(flags *flag.FlagSet) []string {
+	// flags set from environment or profile, which aren't overridden
+	// by later sources
+	set := map[string]bool{}
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || flags.Changed(f.Name) {
+			return
+		}
+		check(f.Value.Set(value), "Invalid value for environment variable "+envName(f.Name))
+		set[f.Name] = true
+	})
+
+	path := c.path
+	if path == "" {
+		path = defaultConfigPath()
+	}
+	config := map[string]any{}
+	_, err := toml.DecodeFile(path, &config)
+	if err != nil && !(os.IsNotExist(err) && !flags.Changed("config")) {
+		check(err, "Cannot read config file")
+	}
+
+	table := config
+	if c.profile != "" {
+		profiles, _ := config["profiles"].(map[string]any)
+		profile, ok := profiles[c.profile].(map[string]any)
+		if !ok {
+			fatal("Profile not found in config file: " + quote(c.profile))
+		}
+		check(applyConfigTable(flags, profile, set), "Invalid profile "+quote(c.profile))
+		if _, ok := profile["sources"]; ok {
+			table = profile
+		}
+	}
+	check(applyConfigTable(flags, config, set), "Invalid config file")
+
+	var sources []string
+	if list, ok := table["sources"].([]any); ok {
+		for _, source := range list {
+			sources = append(sources, fmt.Sprint(source))
+		}
+	}
+	return sources
+}
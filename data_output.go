@@ -0,0 +1,61 @@
+// functions to log post data to --data-output-file
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+var dataOutputTypes = map[string]bool{"template": true, "jsonl": true, "csv": true}
+
+// writeDataOutput logs post data to data output file in configured format.
+func writeDataOutput(postDataMap map[string]any) {
+	if options.DataOutputFile == nil {
+		return
+	}
+	switch options.DataOutputType {
+	case "jsonl":
+		b, err := json.Marshal(postDataMap)
+		check(err)
+		fmt.Fprintln(options.DataOutputFile, string(b))
+	case "csv":
+		record := make([]string, len(options.DataOutputFields))
+		for i, field := range options.DataOutputFields {
+			record[i] = csvValue(postDataMap[field])
+		}
+		writeCSVRecord(record)
+	default:
+		if options.DataOutputFormat != nil {
+			fmt.Fprintln(options.DataOutputFile,
+				formatTemplate(options.DataOutputFormat, postDataMap))
+		}
+	}
+}
+
+func writeCSVRecord(record []string) {
+	w := csv.NewWriter(options.DataOutputFile)
+	check(w.Write(record))
+	w.Flush()
+	check(w.Error())
+}
+
+// csvValue converts a JSON value to a CSV field.
+func csvValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		b, err := json.Marshal(v)
+		check(err)
+		return string(b)
+	}
+}
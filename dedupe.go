@@ -0,0 +1,175 @@
+// functions to detect files which are already downloaded under
+// a different name, using SHA-256 of downloaded content
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var dedupeModes = map[string]bool{
+	"off": true, "delete": true, "hardlink": true, "symlink": true,
+}
+
+// HashIndex maps SHA-256 of downloaded content to absolute path of file.
+// It's stored in sha256sum format, so that it can be appended to.
+// Files removed by --dedupe=delete are stored with deletedPrefix, so
+// that they aren't downloaded again in later runs.
+type HashIndex struct {
+	entries map[string]string
+	deleted map[string]string // path of deleted file to digest
+	file    *os.File
+}
+
+const deletedPrefix = "deleted:"
+
+var hashIndex *HashIndex
+
+// defaultIndexPath returns path of an index file in rrip's config folder
+func defaultIndexPath(name string) string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".rrip-" + name
+	}
+	return filepath.Join(dir, "rrip", name)
+}
+
+// openIndexFile opens an index file for appending, and calls
+// handler for every "<key>  <path>" line already in it.
+func openIndexFile(path string, handler func(key, path string)) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if key, path, ok := strings.Cut(scanner.Text(), "  "); ok {
+			handler(key, path)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// renameInIndexFile replaces paths of renamed files (absolute path of
+// old name to absolute path of new name) in an index file. It's not an
+// error if index file doesn't exist.
+func renameInIndexFile(path string, renamed map[string]string) error {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	lines := strings.SplitAfter(string(b), "\n")
+	changed := false
+	for i, line := range lines {
+		key, oldPath, ok := strings.Cut(strings.TrimSuffix(line, "\n"), "  ")
+		if newPath, found := renamed[oldPath]; ok && found {
+			lines[i] = key + "  " + newPath + "\n"
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, strings.Join(lines, ""))
+		return err
+	})
+}
+
+func loadHashIndex(path string) (*HashIndex, error) {
+	index := &HashIndex{entries: map[string]string{}, deleted: map[string]string{}}
+	var err error
+	index.file, err = openIndexFile(path, func(digest, path string) {
+		if strings.HasPrefix(digest, deletedPrefix) {
+			index.deleted[path] = strings.TrimPrefix(digest, deletedPrefix)
+			return
+		}
+		// later entries override earlier ones
+		index.entries[digest] = path
+	})
+	return index, err
+}
+
+// Lookup returns the file previously recorded with given digest,
+// if it still exists and isn't filename itself.
+func (index *HashIndex) Lookup(digest, filename string) string {
+	path, ok := index.entries[digest]
+	if !ok || path == absPath(filename) {
+		return ""
+	}
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+func (index *HashIndex) Add(digest, filename string) error {
+	path := absPath(filename)
+	index.entries[digest] = path
+	_, err := index.file.WriteString(digest + "  " + path + "\n")
+	return err
+}
+
+// AddDeleted records that filename was deleted as a duplicate
+func (index *HashIndex) AddDeleted(digest, filename string) error {
+	path := absPath(filename)
+	index.deleted[path] = digest
+	_, err := index.file.WriteString(deletedPrefix + digest + "  " + path + "\n")
+	return err
+}
+
+// LookupDeleted returns the original of filename, if filename was
+// deleted as its duplicate in an earlier run and original still exists.
+func (index *HashIndex) LookupDeleted(filename string) string {
+	digest, ok := index.deleted[absPath(filename)]
+	if !ok {
+		return ""
+	}
+	return index.Lookup(digest, filename)
+}
+
+// dedupeFile deletes filename or replaces it with a link to original,
+// according to --dedupe option.
+func dedupeFile(filename, original string) error {
+	if options.Dedupe == "delete" {
+		return os.Remove(filename)
+	}
+	// create link with a temporary name first, so that the downloaded
+	// file is retained if linking isn't possible. A link can't be created
+	// over an existing file, so the temporary file only reserves a name.
+	output, err := createTemp(filename)
+	if err != nil {
+		return err
+	}
+	tmp := output.Name()
+	output.Close()
+	if err := os.Remove(tmp); err != nil {
+		return err
+	}
+	if options.Dedupe == "symlink" {
+		err = os.Symlink(original, tmp)
+	} else {
+		err = os.Link(original, tmp)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenameInIndexFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes")
+	content := "aa  /x/old [a].jpg\nbb  /x/other [b].jpg\ncc  /x/old [a].jpg\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := renameInIndexFile(path, map[string]string{"/x/old [a].jpg": "/x/new [a].jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "aa  /x/new [a].jpg\nbb  /x/other [b].jpg\ncc  /x/new [a].jpg\n"
+	if b, _ := os.ReadFile(path); string(b) != want {
+		t.Errorf("index = %q, want %q", b, want)
+	}
+
+	index, err := loadHashIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.file.Close()
+	if got := index.entries["aa"]; got != "/x/new [a].jpg" {
+		t.Errorf("loaded path = %q, want %q", got, "/x/new [a].jpg")
+	}
+
+	if err := renameInIndexFile(filepath.Join(t.TempDir(), "missing"), nil); err != nil {
+		t.Errorf("missing index: %v", err)
+	}
+}
+
+func TestHashIndexDeleted(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hashes")
+	original := filepath.Join(dir, "original [a].jpg")
+	duplicate := filepath.Join(dir, "repost [b].jpg")
+	if err := os.WriteFile(original, []byte("image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := loadHashIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Add("aa", original); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.AddDeleted("aa", duplicate); err != nil {
+		t.Fatal(err)
+	}
+	index.file.Close()
+
+	// deleted file is remembered in later runs
+	index, err = loadHashIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.file.Close()
+	if got := index.LookupDeleted(duplicate); got != original {
+		t.Errorf("LookupDeleted = %q, want %q", got, original)
+	}
+	if got := index.Lookup("aa", duplicate); got != original {
+		t.Errorf("Lookup = %q, want %q", got, original)
+	}
+	if got := index.LookupDeleted(original); got != "" {
+		t.Errorf("LookupDeleted of original = %q, want none", got)
+	}
+	// download again if original no longer exists
+	os.Remove(original)
+	if got := index.LookupDeleted(duplicate); got != "" {
+		t.Errorf("LookupDeleted = %q after original is removed, want none", got)
+	}
+}
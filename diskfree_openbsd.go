@@ -0,0 +1,16 @@
+//go:build openbsd
+
+package main
+
+import "syscall"
+
+// diskFree returns bytes available to unprivileged users
+// on the filesystem containing path. Fields of Statfs_t
+// are named differently on openbsd.
+func diskFree(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.F_bavail * int64(stat.F_bsize), nil
+}
@@ -0,0 +1,236 @@
+// functions to embed post information into downloaded media files
+// without re-encoding them
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+type embeddedMetadata struct {
+	Title, Author, Subreddit, Permalink string
+	Created                             time.Time
+}
+
+var (
+	jpegMagic  = []byte{0xFF, 0xD8}
+	pngMagic   = []byte("\x89PNG\r\n\x1a\n")
+	gif87Magic = []byte("GIF87a")
+	gif89Magic = []byte("GIF89a")
+	xmpPrefix  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+func newEmbeddedMetadata(post PostData, postDataMap map[string]any) embeddedMetadata {
+	permalink := post.Permalink
+	if strings.HasPrefix(permalink, "/") {
+		permalink = "https://www.reddit.com" + permalink
+	}
+	return embeddedMetadata{
+		Title:     post.Title,
+		Author:    post.Author,
+		Subreddit: post.Subreddit,
+		Permalink: permalink,
+		Created:   postCreatedTime(postDataMap),
+	}
+}
+
+// postCreatedTime returns creation time of the post. Time is read from the
+// map because reddit sends created_utc as a floating point number.
+func postCreatedTime(postDataMap map[string]any) time.Time {
+	created, _ := postDataMap["created_utc"].(float64)
+	return time.Unix(int64(created), 0).UTC()
+}
+
+func (m embeddedMetadata) description() string {
+	return fmt.Sprintf("Posted by u/%s in r/%s", m.Author, m.Subreddit)
+}
+
+// embedMetadata writes post information into JPEG, PNG or GIF file,
+// and sets its modification time to post creation time.
+// Other file types are left unchanged except the modification time.
+func embedMetadata(filename string, meta embeddedMetadata) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var result []byte
+	switch {
+	case bytes.HasPrefix(data, jpegMagic):
+		result, err = embedJPEG(data, meta)
+	case bytes.HasPrefix(data, pngMagic):
+		result, err = embedPNG(data, meta)
+	case bytes.HasPrefix(data, gif87Magic), bytes.HasPrefix(data, gif89Magic):
+		result, err = embedGIF(data, meta)
+	}
+	if err != nil {
+		return err
+	}
+
+	if result != nil {
+		err := writeFileAtomic(filename, func(w io.Writer) error {
+			_, err := w.Write(result)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if meta.Created.Unix() > 0 {
+		return os.Chtimes(filename, time.Now(), meta.Created)
+	}
+	return nil
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func xmpPacket(meta embeddedMetadata) []byte {
+	date := meta.Created.Format(time.RFC3339)
+	return []byte(`<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/">
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">` + xmlEscape(meta.Title) + `</rdf:li></rdf:Alt></dc:title>
+   <dc:creator><rdf:Seq><rdf:li>` + xmlEscape(meta.Author) + `</rdf:li></rdf:Seq></dc:creator>
+   <dc:description><rdf:Alt><rdf:li xml:lang="x-default">` + xmlEscape(meta.description()) + `</rdf:li></rdf:Alt></dc:description>
+   <dc:source>` + xmlEscape(meta.Permalink) + `</dc:source>
+   <xmp:CreateDate>` + date + `</xmp:CreateDate>
+   <photoshop:DateCreated>` + date + `</photoshop:DateCreated>
+   <photoshop:Source>` + xmlEscape("r/"+meta.Subreddit) + `</photoshop:Source>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`)
+}
+
+// embedJPEG inserts an XMP APP1 segment after JFIF / Exif segments,
+// replacing existing XMP segment if any.
+func embedJPEG(data []byte, meta embeddedMetadata) ([]byte, error) {
+	payload := append(append([]byte{}, xmpPrefix...), xmpPacket(meta)...)
+	if len(payload)+2 > 0xFFFF {
+		return nil, errors.New("XMP packet too large for JPEG segment")
+	}
+
+	var out bytes.Buffer
+	out.Write(jpegMagic)
+	pos := len(jpegMagic)
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		if marker != 0xE0 && marker != 0xE1 {
+			break
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end > len(data) {
+			return nil, errors.New("malformed JPEG segment")
+		}
+		// drop existing XMP packet, keep everything else
+		if !(marker == 0xE1 && bytes.HasPrefix(data[pos+4:end], xmpPrefix)) {
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(payload)+2))
+	out.Write(payload)
+	out.Write(data[pos:])
+	return out.Bytes(), nil
+}
+
+func pngChunk(chunkType string, data []byte) []byte {
+	var out bytes.Buffer
+	binary.Write(&out, binary.BigEndian, uint32(len(data)))
+	out.WriteString(chunkType)
+	out.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(data)
+	binary.Write(&out, binary.BigEndian, crc.Sum32())
+	return out.Bytes()
+}
+
+// uncompressed iTXt chunk without language tag
+func pngITXt(keyword, text string) []byte {
+	data := []byte(keyword)
+	data = append(data, 0, 0, 0, 0, 0)
+	data = append(data, text...)
+	return pngChunk("iTXt", data)
+}
+
+// embedPNG inserts iTXt chunks with standard keywords, and an XMP packet,
+// right after IHDR chunk.
+func embedPNG(data []byte, meta embeddedMetadata) ([]byte, error) {
+	pos := len(pngMagic)
+	if pos+8 > len(data) || string(data[pos+4:pos+8]) != "IHDR" {
+		return nil, errors.New("PNG doesn't start with IHDR")
+	}
+	ihdrEnd := pos + 12 + int(binary.BigEndian.Uint32(data[pos:]))
+	if ihdrEnd > len(data) {
+		return nil, errors.New("malformed PNG chunk")
+	}
+
+	var out bytes.Buffer
+	out.Write(data[:ihdrEnd])
+	out.Write(pngITXt("Title", meta.Title))
+	out.Write(pngITXt("Author", meta.Author))
+	out.Write(pngITXt("Description", meta.description()))
+	out.Write(pngITXt("Source", meta.Permalink))
+	out.Write(pngITXt("Creation Time", meta.Created.Format(time.RFC1123)))
+	out.Write(pngITXt("XML:com.adobe.xmp", string(xmpPacket(meta))))
+	out.Write(data[ihdrEnd:])
+	return out.Bytes(), nil
+}
+
+// embedGIF inserts a comment extension after the global color table.
+func embedGIF(data []byte, meta embeddedMetadata) ([]byte, error) {
+	// header + logical screen descriptor
+	pos := 13
+	if len(data) < pos {
+		return nil, errors.New("malformed GIF header")
+	}
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << ((flags & 0x07) + 1)
+	}
+	if pos > len(data) {
+		return nil, errors.New("malformed GIF color table")
+	}
+
+	comment := fmt.Sprintf("Title: %s\nAuthor: u/%s\nSubreddit: r/%s\nPermalink: %s\nDate: %s",
+		meta.Title, meta.Author, meta.Subreddit, meta.Permalink,
+		meta.Created.Format(time.RFC3339))
+
+	var out bytes.Buffer
+	// comment extensions need GIF89a
+	out.Write(gif89Magic)
+	out.Write(data[len(gif89Magic):pos])
+	out.Write([]byte{0x21, 0xFE})
+	for rest := []byte(comment); len(rest) > 0; {
+		n := len(rest)
+		if n > 255 {
+			n = 255
+		}
+		out.WriteByte(byte(n))
+		out.Write(rest[:n])
+		rest = rest[n:]
+	}
+	out.WriteByte(0)
+	out.Write(data[pos:])
+	return out.Bytes(), nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+	"time"
+)
+
+func testImage() image.Image {
+	img := image.NewPaletted(image.Rect(0, 0, 8, 8), color.Palette{color.Black, color.White})
+	img.SetColorIndex(3, 3, 1)
+	return img
+}
+
+func TestEmbedMetadata(t *testing.T) {
+	meta := embeddedMetadata{
+		Title:     "Sunset <over> the & lake " + string(bytes.Repeat([]byte("long "), 100)),
+		Author:    "someone",
+		Subreddit: "pics",
+		Permalink: "https://www.reddit.com/r/pics/comments/x1/",
+		Created:   time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC),
+	}
+	encoders := []struct {
+		name   string
+		encode func(*bytes.Buffer) error
+		embed  func([]byte, embeddedMetadata) ([]byte, error)
+		decode func([]byte) error
+	}{
+		{
+			name:   "jpeg",
+			encode: func(b *bytes.Buffer) error { return jpeg.Encode(b, testImage(), nil) },
+			embed:  embedJPEG,
+			decode: func(b []byte) error { _, err := jpeg.Decode(bytes.NewReader(b)); return err },
+		},
+		{
+			name:   "png",
+			encode: func(b *bytes.Buffer) error { return png.Encode(b, testImage()) },
+			embed:  embedPNG,
+			decode: func(b []byte) error { _, err := png.Decode(bytes.NewReader(b)); return err },
+		},
+		{
+			name:   "gif",
+			encode: func(b *bytes.Buffer) error { return gif.Encode(b, testImage(), nil) },
+			embed:  embedGIF,
+			decode: func(b []byte) error { _, err := gif.Decode(bytes.NewReader(b)); return err },
+		},
+	}
+	for _, enc := range encoders {
+		var original bytes.Buffer
+		if err := enc.encode(&original); err != nil {
+			t.Fatal(err)
+		}
+		embedded, err := enc.embed(original.Bytes(), meta)
+		if err != nil {
+			t.Errorf("%s: %v", enc.name, err)
+			continue
+		}
+		if err := enc.decode(embedded); err != nil {
+			t.Errorf("%s: image can't be decoded after embedding: %v", enc.name, err)
+		}
+		if !bytes.Contains(embedded, []byte(meta.Permalink)) {
+			t.Errorf("%s: permalink not embedded", enc.name)
+		}
+
+		// embedding again replaces (JPEG) or adds to existing metadata,
+		// but the image stays valid
+		again, err := enc.embed(embedded, meta)
+		if err != nil {
+			t.Errorf("%s: embedding again: %v", enc.name, err)
+		} else if err := enc.decode(again); err != nil {
+			t.Errorf("%s: image can't be decoded after embedding again: %v", enc.name, err)
+		}
+		if enc.name == "jpeg" && bytes.Count(again, xmpPrefix) != 1 {
+			t.Errorf("jpeg: %d XMP packets after embedding again, want 1", bytes.Count(again, xmpPrefix))
+		}
+	}
+}
+
+func TestEmbedMalformed(t *testing.T) {
+	meta := embeddedMetadata{Title: "x"}
+	if _, err := embedPNG(append(append([]byte{}, pngMagic...), "garbage"...), meta); err == nil {
+		t.Error("embedPNG accepted malformed PNG")
+	}
+	if _, err := embedGIF(gif89Magic, meta); err == nil {
+		t.Error("embedGIF accepted truncated GIF")
+	}
+	if _, err := embedJPEG([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF}, meta); err == nil {
+		t.Error("embedJPEG accepted malformed segment")
+	}
+}
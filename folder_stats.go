@@ -0,0 +1,126 @@
+// `rrip stats <folder>` which summarizes a downloaded folder
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+type countAndSize struct {
+	count int
+	bytes int64
+}
+
+// printCounts prints entries of counts, largest count first
+func printCounts(title string, counts map[string]*countAndSize, limit int) {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]].count != counts[keys[j]].count {
+			return counts[keys[i]].count > counts[keys[j]].count
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	fmt.Println(title)
+	for _, key := range keys {
+		fmt.Printf("  %-24s %6d  %s\n", key, counts[key].count, size(counts[key].bytes))
+	}
+}
+
+// statsMain handles `rrip stats <folder>`
+func statsMain(args []string) {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	top := flags.Int("top", 10, "Number of subreddits and authors to show")
+	flags.Usage = func() {
+		eprintf("Usage: %s stats <options> <folder>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || *top < 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	folder := flags.Arg(0)
+
+	entries, err := os.ReadDir(folder)
+	check(err, "Cannot read folder")
+	var total countAndSize
+	var withMetadata, withThumbnail int
+	var oldest, newest time.Time
+	types := map[string]*countAndSize{}
+	subreddits := map[string]*countAndSize{}
+	authors := map[string]*countAndSize{}
+	add := func(counts map[string]*countAndSize, key string, bytes int64) {
+		if counts[key] == nil {
+			counts[key] = &countAndSize{}
+		}
+		counts[key].count++
+		counts[key].bytes += bytes
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !isMediaFile(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			eprintln("Cannot read", quote(name)+":", err.Error())
+			continue
+		}
+		total.count++
+		total.bytes += info.Size()
+		add(types, strings.ToLower(filepath.Ext(name)), info.Size())
+		if _, err := os.Stat(filepath.Join(folder, thumbnailName(name))); err == nil {
+			withThumbnail++
+		}
+		metadata := readMetadata(filepath.Join(folder, name))
+		if metadata == nil {
+			continue
+		}
+		withMetadata++
+		if subreddit, ok := metadata["subreddit"].(string); ok {
+			add(subreddits, "r/"+subreddit, info.Size())
+		}
+		if author, ok := metadata["author"].(string); ok {
+			add(authors, "u/"+author, info.Size())
+		}
+		if created := postCreatedTime(metadata); created.Unix() > 0 {
+			if oldest.IsZero() || created.Before(oldest) {
+				oldest = created
+			}
+			if created.After(newest) {
+				newest = created
+			}
+		}
+	}
+
+	fmt.Printf("Files: %d (%s)\n", total.count, size(total.bytes))
+	fmt.Printf("With metadata: %d, with thumbnail: %d\n", withMetadata, withThumbnail)
+	if !oldest.IsZero() {
+		fmt.Printf("Posted between %s and %s\n",
+			oldest.Format("2006-01-02"), newest.Format("2006-01-02"))
+	}
+	if digests, err := readManifest(filepath.Join(folder, manifestFileName)); err == nil {
+		fmt.Printf("Files in %s: %d\n", manifestFileName, len(digests))
+	}
+	printCounts("File types:", types, len(types))
+	if len(subreddits) > 0 && *top > 0 {
+		printCounts("Top subreddits:", subreddits, *top)
+	}
+	if len(authors) > 0 && *top > 0 {
+		printCounts("Top authors:", authors, *top)
+	}
+}
@@ -0,0 +1,238 @@
+// functions to generate a static HTML gallery of downloaded files
+
+package main
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	galleryFileName        = "index.html"
+	defaultGalleryPageSize = 60
+)
+
+type galleryItem struct {
+	File      string `json:"file"`
+	Thumb     string `json:"thumb"`
+	Title     string `json:"title"`
+	Author    string `json:"author"`
+	Subreddit string `json:"subreddit"`
+	Permalink string `json:"permalink"`
+	Score     int64  `json:"score"`
+	Created   int64  `json:"created"`
+	Video     bool   `json:"video"`
+}
+
+// collectGalleryItems lists media files in folder along with post data
+// recorded in their metadata sidecars. Files without sidecar are listed
+// with the information available from file name.
+func collectGalleryItems(folder string) ([]galleryItem, error) {
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return nil, err
+	}
+	var items []galleryItem
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !isMediaFile(name) {
+			continue
+		}
+		item := galleryItem{
+			File:  name,
+			Thumb: name,
+			Title: strings.TrimSuffix(name, filepath.Ext(name)),
+			Video: strings.EqualFold(filepath.Ext(name), ".mp4"),
+		}
+		if _, err := os.Stat(filepath.Join(folder, thumbnailName(name))); err == nil {
+			item.Thumb = filepath.ToSlash(thumbnailName(name))
+		}
+		if id := idFromFileName(name); id != "" {
+			item.Permalink = "https://redd.it/" + id
+		}
+		metadata := readMetadata(filepath.Join(folder, name))
+		if title, ok := metadata["title"].(string); ok {
+			item.Title = title
+		}
+		item.Author, _ = metadata["author"].(string)
+		item.Subreddit, _ = metadata["subreddit"].(string)
+		if permalink, ok := metadata["permalink"].(string); ok {
+			item.Permalink = "https://www.reddit.com" + permalink
+		}
+		if score, ok := metadata["score"].(float64); ok {
+			item.Score = int64(score)
+		}
+		if metadata != nil {
+			item.Created = postCreatedTime(metadata).Unix()
+		}
+		if item.Created <= 0 {
+			if info, err := entry.Info(); err == nil {
+				item.Created = info.ModTime().Unix()
+			}
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Created > items[j].Created
+	})
+	return items, nil
+}
+
+// writeGallery writes a self-contained index.html into folder
+func writeGallery(folder string, pageSize int) error {
+	items, err := collectGalleryItems(folder)
+	if err != nil {
+		return err
+	}
+	output, err := os.Create(filepath.Join(folder, galleryFileName))
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+	return galleryTemplate.Execute(output, map[string]any{
+		"Title":    filepath.Base(absPath(folder)),
+		"Items":    items,
+		"PageSize": pageSize,
+	})
+}
+
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
+
+// galleryMain handles `rrip gallery <folder>`
+func galleryMain(args []string) {
+	flags := flag.NewFlagSet("gallery", flag.ExitOnError)
+	pageSize := flags.Int("page-size", defaultGalleryPageSize, "Number of files per page")
+	flags.Usage = func() {
+		eprintf("Usage: %s gallery <options> <folder>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || *pageSize < 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	folder := flags.Arg(0)
+	check(writeGallery(folder, *pageSize), "Cannot write gallery")
+	eprintln("Gallery written to", filepath.Join(folder, galleryFileName))
+}
+
+var galleryTemplate = template.Must(template.New("gallery").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { margin: 0; font-family: sans-serif; background: #111; color: #ddd; }
+header { padding: 12px 16px; display: flex; flex-wrap: wrap; gap: 12px; align-items: center; }
+h1 { font-size: 1.2em; margin: 0 auto 0 0; }
+select, button { background: #222; color: #ddd; border: 1px solid #444; padding: 4px 8px; }
+#grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(220px, 1fr)); gap: 12px; padding: 0 16px; }
+.item { background: #1b1b1b; border-radius: 4px; overflow: hidden; }
+.item img, .item video { width: 100%; height: 200px; object-fit: cover; display: block; background: #000; }
+.info { padding: 6px 8px; font-size: 0.85em; }
+.title { color: #eee; display: block; margin-bottom: 4px; overflow-wrap: anywhere; }
+.meta { color: #999; }
+a { color: #8cf; text-decoration: none; }
+footer { padding: 16px; text-align: center; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Title}} (<span id="count"></span> files)</h1>
+<label>Sort <select id="sort">
+<option value="newest">Newest</option>
+<option value="oldest">Oldest</option>
+<option value="score">Score</option>
+<option value="title">Title</option>
+<option value="author">Author</option>
+</select></label>
+</header>
+<div id="grid"></div>
+<footer>
+<button id="prev">&larr; Prev</button>
+<span id="page"></span>
+<button id="next">Next &rarr;</button>
+</footer>
+<script>
+const items = {{.Items}} || [];
+const pageSize = {{.PageSize}};
+let page = 0;
+
+const comparators = {
+  newest: (a, b) => b.created - a.created,
+  oldest: (a, b) => a.created - b.created,
+  score: (a, b) => b.score - a.score,
+  title: (a, b) => a.title.localeCompare(b.title),
+  author: (a, b) => a.author.localeCompare(b.author),
+};
+
+function fileUrl(name) {
+  return name.split("/").map(encodeURIComponent).join("/");
+}
+
+function element(tag, attrs, text) {
+  const el = document.createElement(tag);
+  for (const key in attrs) el.setAttribute(key, attrs[key]);
+  if (text !== undefined) el.textContent = text;
+  return el;
+}
+
+function render() {
+  const pages = Math.max(1, Math.ceil(items.length / pageSize));
+  page = Math.min(Math.max(page, 0), pages - 1);
+  const grid = document.getElementById("grid");
+  grid.replaceChildren();
+  for (const item of items.slice(page * pageSize, (page + 1) * pageSize)) {
+    const div = element("div", {class: "item"});
+    const link = element("a", {href: fileUrl(item.file)});
+    if (item.video && item.thumb === item.file) {
+      link.appendChild(element("video", {src: fileUrl(item.file), preload: "metadata", muted: ""}));
+    } else {
+      link.appendChild(element("img", {src: fileUrl(item.thumb), loading: "lazy", alt: item.title}));
+    }
+    div.appendChild(link);
+    const info = element("div", {class: "info"});
+    info.appendChild(element("span", {class: "title"}, item.title));
+    const meta = [];
+    if (item.author) meta.push("u/" + item.author);
+    if (item.subreddit) meta.push("r/" + item.subreddit);
+    if (item.score) meta.push(item.score + " points");
+    if (item.created) meta.push(new Date(item.created * 1000).toLocaleDateString());
+    info.appendChild(element("div", {class: "meta"}, meta.join(" · ")));
+    if (item.permalink) {
+      info.appendChild(element("a", {href: item.permalink, target: "_blank", rel: "noopener"}, "permalink"));
+    }
+    div.appendChild(info);
+    grid.appendChild(div);
+  }
+  document.getElementById("count").textContent = items.length;
+  document.getElementById("page").textContent = "Page " + (page + 1) + " / " + pages;
+  document.getElementById("prev").disabled = page === 0;
+  document.getElementById("next").disabled = page === pages - 1;
+  window.scrollTo(0, 0);
+}
+
+document.getElementById("sort").addEventListener("change", (e) => {
+  items.sort(comparators[e.target.value]);
+  page = 0;
+  render();
+});
+document.getElementById("prev").addEventListener("click", () => { page--; render(); });
+document.getElementById("next").addEventListener("click", () => { page++; render(); });
+render();
+</script>
+</body>
+</html>
+`))
@@ -5,3 +5,11 @@ go 1.18
 require golang.org/x/net v0.0.0-20220425223048-2871e0cb64e4
 
 require github.com/spf13/pflag v1.0.5
+
+require golang.org/x/text v0.16.0
+
+require golang.org/x/image v0.18.0
+
+require github.com/BurntSushi/toml v1.3.2
+
+require gopkg.in/yaml.v3 v3.0.1
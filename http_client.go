@@ -0,0 +1,199 @@
+// HTTP client setup and requests with timeouts
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+// BugFix: with transparent HTTP/2, sometimes reddit servers send HTML instead of JSON
+// So create a custom client
+var client http.Client
+
+// mediaClient shares transport with client, but has no overall timeout,
+// since media downloads can take long. Stalled downloads are detected
+// by stallReader instead.
+var mediaClient http.Client
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// newTransport returns a transport configured according to options
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{
+		Timeout:   seconds(options.ConnectTimeout),
+		KeepAlive: 30 * time.Second,
+	}
+	resolve := parseResolveOverrides(options.Resolve)
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if override, ok := resolve[addr]; ok {
+			log("Connecting to", override, "for", addr)
+			addr = override
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	transport.TLSHandshakeTimeout = seconds(options.ConnectTimeout)
+	transport.ResponseHeaderTimeout = seconds(options.RequestTimeout)
+	if options.UseHTTP1 {
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(authority string, c *tls.Conn) http.RoundTripper{}
+	}
+
+	// Default transport already uses HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	if options.Proxy != "" {
+		proxyUrl, err := url.Parse(options.Proxy)
+		check(err, "Invalid proxy URL")
+		switch proxyUrl.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			fatal("Supported proxy schemes are http, https, socks5 and socks5h")
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: options.Insecure}
+	if options.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(options.CACert)
+		check(err, "Cannot read CA certificate")
+		if !pool.AppendCertsFromPEM(pem) {
+			fatal("No PEM certificates found in " + options.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport
+}
+
+// parseResolveOverrides parses --resolve values of the form
+// host:port:addr into a map from "host:port" to "addr:port"
+func parseResolveOverrides(values []string) map[string]string {
+	overrides := map[string]string{}
+	for _, value := range values {
+		parts := strings.SplitN(value, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			fatal("--resolve should be of the form host:port:address, got " + quote(value))
+		}
+		host, port := parts[0], parts[1]
+		addr := strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]")
+		overrides[net.JoinHostPort(host, port)] = net.JoinHostPort(addr, port)
+	}
+	return overrides
+}
+
+// addNetworkFlags adds options related to HTTP requests to flags. These
+// are shared by the main command and subcommands which access reddit.
+func addNetworkFlags(flags *flag.FlagSet) {
+	flags.StringVarP(&options.UserAgent, "useragent", "U", UserAgent, "UserAgent string")
+	flags.BoolVar(&options.UseHTTP1, "http1", false, "Use HTTP/1.1 to make calls to Reddit API")
+	flags.StringVar(&options.Proxy, "proxy", "",
+		"Proxy URL (http, https, socks5 or socks5h), default is taken from HTTPS_PROXY / HTTP_PROXY")
+	flags.StringVar(&options.CACert, "ca-cert", "",
+		"PEM file with additional CA certificates to trust, eg: of a corporate proxy")
+	flags.BoolVar(&options.Insecure, "insecure", false,
+		"Don't verify TLS certificates (for testing only)")
+	flags.StringArrayVar(&options.Resolve, "resolve", nil,
+		"Connect to given address for host and port, eg: www.reddit.com:443:127.0.0.1 (repeatable)")
+	flags.IntVar(&options.ConnectTimeout, "connect-timeout", 30,
+		"Timeout in seconds for establishing connections, 0 for no timeout")
+	flags.IntVar(&options.RequestTimeout, "request-timeout", 60,
+		"Timeout in seconds for API / HEAD requests and for receiving response headers, 0 for no timeout")
+	flags.IntVar(&options.StallTimeout, "stall-timeout", 60,
+		"Abort a download if no data is received for given seconds, 0 to disable")
+}
+
+// setupClients validates network options and creates HTTP clients
+func setupClients() {
+	for option, value := range map[string]int{
+		"--connect-timeout": options.ConnectTimeout,
+		"--request-timeout": options.RequestTimeout,
+		"--stall-timeout":   options.StallTimeout,
+	} {
+		if value < 0 {
+			fatal("Invalid value for option " + option)
+		}
+	}
+
+	transport := newTransport()
+	client = http.Client{Transport: transport, Timeout: seconds(options.RequestTimeout)}
+	mediaClient = http.Client{Transport: transport}
+}
+
+// stallReader wraps response body, and cancels the request if no data
+// arrives for timeout duration. Time is counted only while a Read is
+// waiting for data, so that pauses of the caller (eg: sleeping for
+// --limit-rate) aren't taken as stalls.
+type stallReader struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	stalled int32
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	r.timer.Reset(r.timeout)
+	n, err := r.body.Read(p)
+	r.timer.Stop()
+	if err != nil && atomic.LoadInt32(&r.stalled) == 1 {
+		err = fmt.Errorf("stalled, no data received for %s", r.timeout)
+	}
+	return n, err
+}
+
+func (r *stallReader) Close() error {
+	r.timer.Stop()
+	r.cancel()
+	return r.body.Close()
+}
+
+func newRequest(ctx context.Context, method, url, acceptMimeType string) *http.Request {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	check(err)
+	req.Header.Add("User-Agent", options.UserAgent)
+	if acceptMimeType != "" {
+		req.Header.Add("Accept", acceptMimeType)
+	}
+	return req
+}
+
+// FetchMedia makes a GET request for a media file. Body of the response
+// is aborted if no data arrives for --stall-timeout seconds.
+func FetchMedia(url string) (*http.Response, error) {
+	if options.StallTimeout == 0 {
+		return mediaClient.Do(newRequest(context.Background(), "GET", url, ""))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	response, err := mediaClient.Do(newRequest(ctx, "GET", url, ""))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	reader := &stallReader{body: response.Body, cancel: cancel,
+		timeout: seconds(options.StallTimeout)}
+	reader.timer = time.AfterFunc(reader.timeout, func() {
+		atomic.StoreInt32(&reader.stalled, 1)
+		cancel()
+	})
+	reader.timer.Stop()
+	response.Body = reader
+	return response, nil
+}
@@ -0,0 +1,65 @@
+// `rrip info <post>` which prints current data of a post
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	postIdRegex   = regexp.MustCompile(`^(?:t3_)?([0-9a-z]+)$`)
+	postLinkRegex = regexp.MustCompile(`(?:/comments/|/gallery/|redd\.it/)([0-9a-z]+)`)
+)
+
+// parsePostId returns post ID from an ID (with or without t3_ prefix),
+// or a link to post. Returns empty string if it's none of these.
+func parsePostId(s string) string {
+	if match := postIdRegex.FindStringSubmatch(s); match != nil {
+		return match[1]
+	}
+	if match := postLinkRegex.FindStringSubmatch(s); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// infoMain handles `rrip info <post>`
+func infoMain(args []string) {
+	flags := flag.NewFlagSet("info", flag.ExitOnError)
+	format := flags.StringP("format", "f", "", "Template for printing post data, default is JSON")
+	flags.BoolVarP(&options.Debug, "verbose", "v", false, "Enable verbose output")
+	addNetworkFlags(flags)
+	config := addConfigFlags(flags)
+	flags.Usage = func() {
+		eprintf("Usage: %s info <options> <post ID or link>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	config.apply(flags)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	id := parsePostId(flags.Arg(0))
+	if id == "" {
+		fatal("Not a post ID or link: " + quote(flags.Arg(0)))
+	}
+	setupClients()
+
+	posts, err := fetchPostInfo([]string{id})
+	check(err, "Cannot fetch post info")
+	post, ok := posts[id]
+	if !ok {
+		fatal("Post not found: " + id)
+	}
+	if *format == "" {
+		fmt.Println(marshallIndent(post))
+		return
+	}
+	post["quoted_title"] = quote(fmt.Sprint(post["title"]))
+	fmt.Println(formatTemplate(createTemplate("format", *format), post))
+}
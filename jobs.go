@@ -0,0 +1,448 @@
+// `rrip run jobs.yaml` which downloads from many sources, each with
+// its own options. Every job runs as a separate `rrip download` process,
+// since options and stats are global, and a failing job shouldn't stop
+// the others.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// JobFile is the format of jobs.yaml. Each job is a map of download
+// options (without leading --), along with name and source(s) of the job.
+// Options in defaults apply to every job, unless job overrides them.
+//
+//	concurrency: 2
+//	defaults:
+//	  max-size: 5000
+//	jobs:
+//	  - name: wallpapers
+//	    sources: [r/wallpaper, r/wallpapers]
+//	    sort: top-week
+//	    min-score: 500
+//	  - source: u/someone
+//	    folder: someone
+type JobFile struct {
+	Concurrency int
+	Defaults    map[string]any
+	Jobs        []map[string]any
+}
+
+type jobResult struct {
+	name     string
+	err      error
+	stats    Stats
+	duration time.Duration
+}
+
+// jobSources returns listing paths of job. u/name is a shorthand
+// for posts submitted by the user.
+func jobSources(job map[string]any) []string {
+	var sources []string
+	switch value := job["sources"].(type) {
+	case []any:
+		for _, source := range value {
+			sources = append(sources, fmt.Sprint(source))
+		}
+	case string:
+		sources = append(sources, value)
+	}
+	if source, ok := job["source"].(string); ok {
+		sources = append(sources, source)
+	}
+	for i, source := range sources {
+		if strings.HasPrefix(source, "u/") {
+			user := strings.TrimSuffix(strings.TrimPrefix(source, "u/"), "/")
+			sources[i] = "user/" + user + "/submitted"
+		}
+	}
+	return sources
+}
+
+// jobName returns name of job, or its sources if it has no name
+func jobName(job map[string]any, index int) string {
+	if name, ok := job["name"].(string); ok && name != "" {
+		return name
+	}
+	if sources := jobSources(job); len(sources) > 0 {
+		return strings.Join(sources, ",")
+	}
+	if search, ok := job["search"].(string); ok {
+		return "search:" + search
+	}
+	return fmt.Sprint("job-", index+1)
+}
+
+// optionArgs converts job options to command line flags, eg: min-score: 500
+// to --min-score=500. Lists and maps are given as repeated flags.
+func optionArgs(name string, value any) []string {
+	switch value := value.(type) {
+	case nil:
+		return nil
+	case bool:
+		return []string{fmt.Sprintf("--%s=%t", name, value)}
+	case []any:
+		var args []string
+		for _, item := range value {
+			args = append(args, optionArgs(name, item)...)
+		}
+		return args
+	case map[string]any:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var args []string
+		for _, key := range keys {
+			args = append(args, fmt.Sprintf("--%s=%s=%v", name, key, value[key]))
+		}
+		return args
+	}
+	return []string{fmt.Sprintf("--%s=%v", name, value)}
+}
+
+// jobArgs returns arguments of `rrip download` for job. Keys can use
+// either - or _ as separator, eg: min_score in job overrides min-score
+// in defaults.
+func jobArgs(defaults, job map[string]any) []string {
+	merged := map[string]any{}
+	for _, table := range []map[string]any{defaults, job} {
+		keys := make([]string, 0, len(table))
+		for key := range table {
+			keys = append(keys, key)
+		}
+		// so that result doesn't depend on map order if both are used
+		sort.Strings(keys)
+		for _, key := range keys {
+			merged[strings.ReplaceAll(key, "_", "-")] = table[key]
+		}
+	}
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		switch key {
+		case "name", "source", "sources":
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var args []string
+	for _, key := range keys {
+		args = append(args, optionArgs(key, merged[key])...)
+	}
+	return append(args, jobSources(job)...)
+}
+
+// options of download which can't be combined with --dry-run
+var dryRunExcluded = []string{"--max-size=", "--max-storage="}
+
+// withoutDryRunExcluded returns args without options which
+// can't be combined with --dry-run
+func withoutDryRunExcluded(args []string) []string {
+	var result []string
+outer:
+	for _, arg := range args {
+		for _, prefix := range dryRunExcluded {
+			if strings.HasPrefix(arg, prefix) {
+				continue outer
+			}
+		}
+		result = append(result, arg)
+	}
+	return result
+}
+
+// logFileName returns name of log file of job. It's sanitized using
+// portable profile regardless of --sanitize, since job name can be
+// anything.
+func logFileName(name string) string {
+	profile := sanitizeProfiles["portable"]
+	var b strings.Builder
+	for _, r := range profile.normalize(name) {
+		b.WriteString(sanitizeRune(profile, r))
+	}
+	return profile.finish(b.String()) + ".log"
+}
+
+// prefixWriter writes every line written to it with a prefix, so that
+// output of concurrent jobs can be told apart. Progress updates using
+// carriage return are dropped.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	prefix string
+	out    io.Writer
+}
+
+func (w prefixWriter) copyLines(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.LastIndexByte(line, '\r'); i != -1 {
+			line = line[i+1:]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		w.mu.Lock()
+		fmt.Fprintln(w.out, w.prefix+line)
+		w.mu.Unlock()
+	}
+}
+
+// runJob runs `rrip download` for a job, and returns its stats
+func runJob(executable, name string, args []string, logDir string, prefix *sync.Mutex) (result jobResult) {
+	result.name = name
+	start := time.Now()
+	defer func() {
+		result.duration = time.Since(start)
+	}()
+
+	statsFile, err := os.CreateTemp("", "rrip-stats-*.json")
+	if err != nil {
+		result.err = err
+		return result
+	}
+	statsFile.Close()
+	defer os.Remove(statsFile.Name())
+
+	cmd := exec.Command(executable, append([]string{"download", "--stats-json=" + statsFile.Name()}, args...)...)
+	var wait func()
+	switch {
+	case logDir != "":
+		logFile, err := os.Create(filepath.Join(logDir, logFileName(name)))
+		if err != nil {
+			result.err = err
+			return result
+		}
+		defer logFile.Close()
+		cmd.Stdout, cmd.Stderr = logFile, logFile
+	case prefix != nil:
+		pipe, err := cmd.StderrPipe()
+		if err != nil {
+			result.err = err
+			return result
+		}
+		cmd.Stdout = cmd.Stderr
+		w := prefixWriter{mu: prefix, prefix: "[" + name + "] ", out: os.Stderr}
+		done := make(chan struct{})
+		go func() {
+			w.copyLines(pipe)
+			close(done)
+		}()
+		wait = func() { <-done }
+	default:
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	}
+
+	log("Running:", executable, strings.Join(cmd.Args[1:], " "))
+	if err := cmd.Start(); err != nil {
+		result.err = err
+		return result
+	}
+	if wait != nil {
+		wait()
+	}
+	if err := cmd.Wait(); err != nil {
+		result.err = err
+	}
+	b, err := os.ReadFile(statsFile.Name())
+	if err == nil && len(b) > 0 {
+		err = json.Unmarshal(b, &result.stats)
+	} else if err == nil && result.err == nil {
+		err = errors.New("job didn't report stats")
+	}
+	if result.err == nil {
+		result.err = err
+	}
+	return result
+}
+
+func printJobResults(results []jobResult) {
+	var total Stats
+	failed := 0
+	fmt.Println(horizontalDashedLine)
+	fmt.Printf("%-24s %-8s %9s %7s %7s %7s %10s %8s\n",
+		"Job", "Status", "Processed", "Saved", "Repeat", "Failed", "Stored", "Time")
+	for _, r := range results {
+		status := "OK"
+		if r.err != nil {
+			status = "FAILED"
+			failed++
+		} else if r.stats.StopReason != "" {
+			status = "STOPPED"
+		}
+		fmt.Printf("%-24.24s %-8s %9d %7d %7d %7d %10s %8s\n", r.name, status,
+			r.stats.Processed, r.stats.Saved, r.stats.Repeated, r.stats.Failed,
+			size(r.stats.StoredBytes), r.duration.Round(time.Second))
+		total.Processed += r.stats.Processed
+		total.Saved += r.stats.Saved
+		total.Repeated += r.stats.Repeated
+		total.Failed += r.stats.Failed
+		total.StoredBytes += r.stats.StoredBytes
+	}
+	fmt.Println(horizontalDashedLine)
+	fmt.Printf("%-24s %-8s %9d %7d %7d %7d %10s\n", "Total",
+		fmt.Sprintf("%d/%d", len(results)-failed, len(results)),
+		total.Processed, total.Saved, total.Repeated, total.Failed, size(total.StoredBytes))
+	for _, r := range results {
+		if r.err != nil {
+			fmt.Printf("%s: %s\n", r.name, r.err.Error())
+		}
+		if r.stats.StopReason != "" {
+			fmt.Printf("%s: stopped: %s\n", r.name, r.stats.StopReason)
+		}
+	}
+}
+
+// runMain handles `rrip run jobs.yaml`
+func runMain(args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	concurrency := flags.IntP("concurrency", "j", 0,
+		"Number of jobs to run at once, overrides concurrency in job file (default 1). "+
+			"--limit-rate is divided between jobs running at once")
+	logDir := flags.String("log-dir", "", "Write output of each job to <job name>.log in this folder")
+	dryRun := flags.BoolP("dry-run", "d", false, "Run all jobs in dry run mode (ignoring max-size and max-storage)")
+	only := flags.StringSlice("only", nil, "Run only jobs with given names")
+	flags.BoolVarP(&options.Debug, "verbose", "v", false, "Enable verbose output")
+	flags.Usage = func() {
+		eprintf("Usage: %s run <options> <jobs.yaml>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || *concurrency < 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	b, err := os.ReadFile(flags.Arg(0))
+	check(err, "Cannot read job file")
+	var jobFile JobFile
+	check(yaml.Unmarshal(b, &jobFile), "Cannot parse job file")
+	if *concurrency == 0 {
+		*concurrency = max(jobFile.Concurrency, 1)
+	}
+	if *logDir != "" {
+		check(os.MkdirAll(*logDir, 0o755), "Cannot create log folder")
+	}
+	executable, err := os.Executable()
+	check(err, "Cannot find rrip executable")
+
+	selected := map[string]bool{}
+	for _, name := range *only {
+		selected[name] = true
+	}
+	type job struct {
+		name string
+		args []string
+	}
+	var jobs []job
+	names := map[string]bool{}
+	for i, j := range jobFile.Jobs {
+		name := jobName(j, i)
+		if names[name] {
+			fatal("Duplicate job name: " + quote(name))
+		}
+		names[name] = true
+		if len(selected) > 0 && !selected[name] {
+			continue
+		}
+		args := jobArgs(jobFile.Defaults, j)
+		if *dryRun {
+			args = append([]string{"--dry-run"}, withoutDryRunExcluded(args)...)
+		}
+		jobs = append(jobs, job{name, args})
+	}
+	for name := range selected {
+		if !names[name] {
+			fatal("No job named " + quote(name))
+		}
+	}
+	// concurrent jobs share --limit-rate, instead of each using all of it
+	if share := min(*concurrency, len(jobs)); share > 1 {
+		for i := range jobs {
+			jobs[i].args = append([]string{fmt.Sprint("--limit-rate-share=", share)}, jobs[i].args...)
+		}
+	}
+
+	var prefix *sync.Mutex
+	if *concurrency > 1 {
+		prefix = &sync.Mutex{}
+	}
+	// Ctrl+C is received by running jobs too. Wait for them to exit
+	// and report their stats, but don't start any more jobs.
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	interrupted := make(chan struct{})
+	go func() {
+		<-interrupt
+		eprintln("Interrupt received, waiting for running jobs to exit...")
+		close(interrupted)
+	}()
+	stopped := func() bool {
+		select {
+		case <-interrupted:
+			return true
+		default:
+			return false
+		}
+	}
+
+	results := make([]jobResult, len(jobs))
+	semaphore := make(chan struct{}, *concurrency)
+	var wg sync.WaitGroup
+	started := 0
+	for i, j := range jobs {
+		select {
+		case semaphore <- struct{}{}:
+		case <-interrupted:
+		}
+		if stopped() {
+			break
+		}
+		started++
+		wg.Add(1)
+		go func(i int, name string, args []string) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			if *concurrency == 1 {
+				eprintln(horizontalDashedLine)
+				eprintf("Job %d/%d: %s\n", i+1, len(jobs), name)
+			}
+			results[i] = runJob(executable, name, args, *logDir, prefix)
+			if results[i].err != nil {
+				eprintf("Job %s failed: %s\n", quote(name), results[i].err.Error())
+			}
+		}(i, j.name, j.args)
+	}
+	wg.Wait()
+
+	printJobResults(results[:started])
+	if started < len(jobs) {
+		fmt.Printf("%d jobs not started due to interrupt\n", len(jobs)-started)
+		os.Exit(1)
+	}
+	for _, r := range results {
+		if r.err != nil {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJobArgs(t *testing.T) {
+	tests := []struct {
+		name          string
+		defaults, job map[string]any
+		want          []string
+	}{
+		{
+			name: "options and sources",
+			job: map[string]any{
+				"name": "walls", "sources": []any{"r/wallpaper", "r/wallpapers"},
+				"sort": "top-week", "min-score": 500,
+			},
+			want: []string{"--min-score=500", "--sort=top-week", "r/wallpaper", "r/wallpapers"},
+		},
+		{
+			name:     "job overrides defaults",
+			defaults: map[string]any{"max-size": 5000, "min-score": 10},
+			job:      map[string]any{"source": "r/pics", "min_score": 20},
+			want:     []string{"--max-size=5000", "--min-score=20", "r/pics"},
+		},
+		{
+			name: "user shorthand",
+			job:  map[string]any{"source": "u/someone/"},
+			want: []string{"user/someone/submitted"},
+		},
+		{
+			name: "booleans, lists and tables",
+			job: map[string]any{
+				"source": "r/pics", "write-metadata": true, "prefer-preview": false,
+				"dominant-color":   []any{"black", "blue"},
+				"sanitize-replace": map[string]any{"&": "and", "#": ""},
+				"folder":           nil,
+			},
+			want: []string{
+				"--dominant-color=black", "--dominant-color=blue", "--prefer-preview=false",
+				"--sanitize-replace=#=", "--sanitize-replace=&=and", "--write-metadata=true", "r/pics",
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := jobArgs(tt.defaults, tt.job); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: jobArgs = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestJobName(t *testing.T) {
+	tests := []struct {
+		job  map[string]any
+		want string
+	}{
+		{map[string]any{"name": "walls", "source": "r/pics"}, "walls"},
+		{map[string]any{"sources": []any{"r/a", "r/b"}}, "r/a,r/b"},
+		{map[string]any{"source": "u/someone"}, "user/someone/submitted"},
+		{map[string]any{"search": "nebula"}, "search:nebula"},
+		{map[string]any{}, "job-3"},
+	}
+	for _, tt := range tests {
+		if got := jobName(tt.job, 2); got != tt.want {
+			t.Errorf("jobName(%v) = %q, want %q", tt.job, got, tt.want)
+		}
+	}
+}
+
+func TestWithoutDryRunExcluded(t *testing.T) {
+	args := []string{"--max-size=5000", "--min-score=5", "--max-storage=100", "--max-files=3", "r/pics"}
+	want := []string{"--min-score=5", "--max-files=3", "r/pics"}
+	if got := withoutDryRunExcluded(args); !reflect.DeepEqual(got, want) {
+		t.Errorf("withoutDryRunExcluded = %q, want %q", got, want)
+	}
+}
+
+func TestLogFileName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"wallpapers", "wallpapers.log"},
+		{"r/a,r/b", "rarb.log"},
+		{"a/b: weird", "ab-_weird.log"},
+		{"Café", "Cafe.log"},
+	}
+	for _, tt := range tests {
+		if got := logFileName(tt.name); got != tt.want {
+			t.Errorf("logFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
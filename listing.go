@@ -0,0 +1,160 @@
+// options shared by subcommands which traverse a listing, and
+// `rrip list` which prints posts without downloading them
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+const defaultListFormat = "{{.id}}  {{.score}}  {{.url}}  {{.title}}"
+
+// listingFlags holds options which decide posts fetched from reddit
+// and filters applied on them. These are shared by download and list.
+type listingFlags struct {
+	titleContains, titleNotContains string
+	flairContains, flairNotContains string
+	linkContains, linkNotContains   string
+	templateFilter                  string
+}
+
+func addListingFlags(flags *flag.FlagSet) *listingFlags {
+	l := &listingFlags{}
+	flags.StringVar(&options.After, "after", "", "Get posts after the given ID")
+	flags.StringVar(&options.Sort, "sort", "", "Sort: best|hot|new|rising|top-<all|year|month|week|day>")
+	flags.StringVar(&options.Search, "search", "", "Search for given term")
+	flags.IntVar(&options.MaxFiles, "max-files", -1, "Max number of files to download (+ve), -1 for no limit")
+	flags.IntVar(&options.MinScore, "min-score", 0, "Minimum score of the post to download")
+	flags.IntVar(&options.EntriesLimit, "entries-limit", 100, "Number of entries to fetch in one API request (devel)")
+
+	flags.StringVar(&l.titleContains, "title-contains", "", "Download if "+
+		"title contains substring matching given regex")
+	flags.StringVar(&l.flairContains, "flair-contains", "", "Download if "+
+		"flair contains substring matching given regex (works only if flair is plaintext)")
+	flags.StringVar(&l.linkContains, "link-contains", "", "Download if "+
+		"posted link contains substring matching given regex")
+
+	flags.StringVar(&l.titleNotContains, "title-not-contains", "", "Download if "+
+		"title does not contain substring matching given regex")
+	flags.StringVar(&l.flairNotContains, "flair-not-contains", "", "Download if "+
+		"flair does not contain substring matching given regex")
+	flags.StringVar(&l.linkNotContains, "link-not-contains", "", "Download if "+
+		"posted link does not contain substring matching given regex")
+	flags.StringVar(&l.templateFilter, "template-filter", "", "Posts will be ignored if this template evaluates to \"false\", \"0\" or empty string")
+
+	flags.Float64Var(&options.MaxMeanLuminance, "max-mean-luminance", -1,
+		"Skip images whose preview has mean luminance (0-1) more than given value, -1 for no limit")
+	flags.Float64Var(&options.MinBlackRatio, "min-black-ratio", -1,
+		"Skip images whose preview has fraction of black pixels (0-1) less than given value, -1 for no limit")
+	flags.StringSliceVar(&options.DominantColors, "dominant-color", nil,
+		"Download only if dominant color of preview is one of given colors: "+
+			"black, white, gray, red, orange, yellow, green, cyan, blue, purple, pink")
+	return l
+}
+
+// apply validates listing options after flags are parsed. fields (of
+// data output) and templates may refer to colour stats of preview.
+func (l *listingFlags) apply(fields []string, templates ...string) {
+	if options.MaxFiles < 1 && options.MaxFiles != -1 {
+		fatal("Invalid value for option --max-files")
+	}
+
+	for _, color := range options.DominantColors {
+		if !colorNames[color] {
+			fatal("Unknown color passed to --dominant-color: " + color)
+		}
+	}
+
+	options.ColorStats = usesColorStats(fields, append(templates, l.templateFilter)...)
+
+	if options.After != "" && !strings.HasPrefix(options.After, "t3_") {
+		options.After = "t3_" + options.After
+	}
+
+	regexVals := []struct {
+		re  **regexp.Regexp
+		opt string
+	}{
+		{&options.TitleContains, l.titleContains},
+		{&options.TitleNotContains, l.titleNotContains},
+		{&options.FlairContains, l.flairContains},
+		{&options.FlairNotContains, l.flairNotContains},
+		{&options.LinkContains, l.linkContains},
+		{&options.LinkNotContains, l.linkNotContains},
+	}
+
+	for _, rv := range regexVals {
+		if rv.opt != "" {
+			*(rv.re) = regexp.MustCompile(rv.opt)
+		}
+	}
+
+	if l.templateFilter != "" {
+		options.TemplateFilter = createTemplate("template-filter", l.templateFilter)
+	}
+}
+
+// listingPath returns the listing path from positional arguments, or
+// from sources in config file if there are none. Multiple subreddits
+// are combined into a multireddit, eg: r/pics+earthporn
+func listingPath(flags *flag.FlagSet, sources []string) string {
+	if flags.NArg() > 0 {
+		sources = flags.Args()
+	}
+	if len(sources) == 0 && options.Search == "" {
+		flags.Usage()
+		os.Exit(1)
+	}
+	if len(sources) == 0 {
+		return ""
+	}
+	if len(sources) == 1 {
+		return strings.TrimSuffix(sources[0], "/")
+	}
+	var subreddits []string
+	for _, source := range sources {
+		subreddit := strings.TrimPrefix(strings.TrimSuffix(source, "/"), "r/")
+		if subreddit == source || strings.Contains(subreddit, "/") {
+			fatal("Only subreddits (r/name) can be combined, got " + quote(source))
+		}
+		subreddits = append(subreddits, subreddit)
+	}
+	return "r/" + strings.Join(subreddits, "+")
+}
+
+// listMain handles `rrip list <r/subreddit>`
+func listMain(args []string) {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	format := flags.StringP("format", "f", defaultListFormat, "Template for printing each post")
+	flags.BoolVarP(&options.Debug, "verbose", "v", false, "Enable verbose output")
+	listing := addListingFlags(flags)
+	addNetworkFlags(flags)
+	config := addConfigFlags(flags)
+	flags.Usage = func() {
+		eprintf("Usage: %s list <options> <r/subreddit>...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	path := listingPath(flags, config.apply(flags))
+	listing.apply(nil, *format)
+	setupClients()
+	options.ListOnly = true
+	tm := createTemplate("format", *format)
+
+	go Traverse(path, func(post PostData, postMap map[string]any) {
+		if !passesFilters(post, postMap, previewFetcher(post)) {
+			return
+		}
+		fmt.Println(formatTemplate(tm, postMap))
+		stats.Saved += 1
+		if stats.Saved == options.MaxFiles {
+			Finish()
+		}
+	})
+	<-completion
+}
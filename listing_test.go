@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	flag "github.com/spf13/pflag"
+)
+
+func TestListingPath(t *testing.T) {
+	tests := []struct {
+		args, sources []string
+		want          string
+	}{
+		{args: []string{"r/pics"}, want: "r/pics"},
+		{args: []string{"r/pics/"}, want: "r/pics"},
+		{args: []string{"user/someone/submitted"}, want: "user/someone/submitted"},
+		{args: []string{"r/pics", "r/earthporn/"}, want: "r/pics+earthporn"},
+		{sources: []string{"r/a", "r/b", "r/c"}, want: "r/a+b+c"},
+		// arguments override sources in config file
+		{args: []string{"r/pics"}, sources: []string{"r/a", "r/b"}, want: "r/pics"},
+	}
+	for _, tt := range tests {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		if err := flags.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		if got := listingPath(flags, tt.sources); got != tt.want {
+			t.Errorf("listingPath(%q, %q) = %q, want %q", tt.args, tt.sources, got, tt.want)
+		}
+	}
+}
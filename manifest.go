@@ -0,0 +1,262 @@
+// functions to write a SHA256SUMS manifest of target folder, and to
+// verify files against it
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// manifest is compatible with `sha256sum -c SHA256SUMS`
+const manifestFileName = "SHA256SUMS"
+
+// manifestFile is the manifest opened for appending, if --write-manifest
+// is given. Lines are appended as files are saved, and the manifest is
+// rewritten at the end of run.
+var manifestFile *os.File
+
+// readManifest returns digests of files listed in manifest at path.
+// Both text ("digest  name") and binary ("digest *name") lines are read.
+func readManifest(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	digests := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		digest, name, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || len(name) < 2 || len(digest) != sha256.Size*2 {
+			continue
+		}
+		// later entries override earlier ones
+		digests[filepath.FromSlash(name[1:])] = strings.ToLower(digest)
+	}
+	return digests, scanner.Err()
+}
+
+// writeManifest replaces manifest at path with given digests, sorted by name
+func writeManifest(path string, digests map[string]string) error {
+	names := make([]string, 0, len(digests))
+	for name := range digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(digests[name] + "  " + filepath.ToSlash(name) + "\n")
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, sb.String())
+		return err
+	})
+}
+
+func hashFile(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func openManifest(folder string) (*os.File, error) {
+	return os.OpenFile(filepath.Join(folder, manifestFileName),
+		os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
+
+// addToManifest appends digest of a saved file to manifest
+func addToManifest(digest, filename string) {
+	_, err := manifestFile.WriteString(digest + "  " + filepath.ToSlash(filename) + "\n")
+	if err != nil {
+		eprintln("Cannot update manifest:", err.Error())
+	}
+}
+
+// updateManifest rewrites manifest of folder, leaving out files which
+// no longer exist. If hashNew is true, media files which aren't listed
+// (eg: downloaded before --write-manifest was used) are hashed and added.
+func updateManifest(folder string, hashNew bool) error {
+	path := filepath.Join(folder, manifestFileName)
+	digests, err := readManifest(path)
+	if os.IsNotExist(err) {
+		digests = map[string]string{}
+	} else if err != nil {
+		return err
+	}
+	for name := range digests {
+		if _, err := os.Stat(filepath.Join(folder, name)); os.IsNotExist(err) {
+			delete(digests, name)
+		}
+	}
+	if hashNew {
+		entries, err := os.ReadDir(folder)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if _, ok := digests[name]; ok || entry.IsDir() || !isMediaFile(name) {
+				continue
+			}
+			digest, err := hashFile(filepath.Join(folder, name))
+			if err != nil {
+				return err
+			}
+			digests[name] = digest
+		}
+	}
+	return writeManifest(path, digests)
+}
+
+// redownload fetches filename again from final_url recorded in its
+// sidecar, and replaces the file only if the new copy matches digest, or
+// the hash of original download recorded in sidecar (if file was changed
+// by post processing or embedding metadata). Returns digest of new file.
+func redownload(filename, digest string) (string, error) {
+	metadata := readMetadata(filename)
+	link, _ := metadata["final_url"].(string)
+	if link == "" || strings.HasPrefix(link, "!") {
+		return "", errors.New("no final_url recorded in metadata")
+	}
+	downloadDigest, _ := metadata["rrip_sha256"].(string)
+	response, err := FetchMedia(link)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return "", errors.New("cannot fetch file: " + response.Status)
+	}
+	output, err := createTemp(filename)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(output, hash), response.Body)
+	output.Close()
+	newDigest := hex.EncodeToString(hash.Sum(nil))
+	if err == nil && newDigest != digest && newDigest != downloadDigest {
+		err = errors.New("downloaded file doesn't match manifest")
+	}
+	if err == nil {
+		err = os.Chmod(output.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(output.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(output.Name())
+		return "", err
+	}
+	return newDigest, nil
+}
+
+// verifyMain handles `rrip verify <folder>`
+func verifyMain(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	redownloadFiles := flags.Bool("redownload", false,
+		"Download missing and changed files again from final_url recorded in their metadata. "+
+			"Files changed by post processing are restored as originally downloaded")
+	flags.BoolVarP(&options.Debug, "verbose", "v", false, "Enable verbose output")
+	addNetworkFlags(flags)
+	config := addConfigFlags(flags)
+	flags.Usage = func() {
+		eprintf("Usage: %s verify <options> <folder>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	config.apply(flags)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	setupClients()
+	// file names in manifest and sidecars are relative to folder
+	check(os.Chdir(flags.Arg(0)), "Cannot open folder")
+
+	digests, err := readManifest(manifestFileName)
+	check(err, "Cannot read manifest")
+	names := make([]string, 0, len(digests))
+	for name := range digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var ok, missing, changed, extra, repaired int
+	rehashed := false
+	for _, name := range names {
+		var problem string
+		digest, err := hashFile(name)
+		switch {
+		case os.IsNotExist(err):
+			problem = "Missing"
+			missing++
+		case err != nil:
+			problem = "Unreadable (" + err.Error() + ")"
+			changed++
+		case digest != digests[name]:
+			problem = "Changed"
+			changed++
+		default:
+			ok++
+			continue
+		}
+		if !*redownloadFiles {
+			fmt.Printf("%s: %s\n", problem, name)
+			continue
+		}
+		newDigest, err := redownload(name, digests[name])
+		if err != nil {
+			fmt.Printf("%s: %s [Cannot re-download: %s]\n", problem, name, err.Error())
+			continue
+		}
+		if newDigest != digests[name] {
+			// original download, without post processing
+			fmt.Printf("%s: %s [Re-downloaded as originally downloaded]\n", problem, name)
+			digests[name] = newDigest
+			rehashed = true
+		} else {
+			fmt.Printf("%s: %s [Re-downloaded]\n", problem, name)
+		}
+		repaired++
+	}
+	if rehashed {
+		check(writeManifest(manifestFileName, digests), "Cannot update manifest")
+	}
+
+	entries, err := os.ReadDir(".")
+	check(err, "Cannot read folder")
+	for _, entry := range entries {
+		if _, listed := digests[entry.Name()]; !listed && !entry.IsDir() && isMediaFile(entry.Name()) {
+			fmt.Printf("Extra: %s\n", entry.Name())
+			extra++
+		}
+	}
+
+	eprintf("%d OK, %d missing, %d changed, %d extra", ok, missing, changed, extra)
+	if *redownloadFiles {
+		eprintf(", %d re-downloaded", repaired)
+	}
+	eprintln()
+	if missing+changed-repaired > 0 {
+		os.Exit(1)
+	}
+}
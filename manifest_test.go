@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadManifest(t *testing.T) {
+	digestA := strings.Repeat("a", 64)
+	digestB := strings.Repeat("B", 64)
+	path := filepath.Join(t.TempDir(), manifestFileName)
+	content := digestA + "  text mode [x1].jpg\n" +
+		digestB + " *binary mode [x2].png\n" +
+		"not a manifest line\n" +
+		"abc  too short digest.jpg\n" +
+		strings.Repeat("c", 64) + "  later [x1].jpg\n" +
+		digestA + "  later [x1].jpg\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readManifest(path)
+	want := map[string]string{
+		"text mode [x1].jpg":   digestA,
+		"binary mode [x2].png": strings.ToLower(digestB),
+		"later [x1].jpg":       digestA,
+	}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("readManifest = %v, %v, want %v", got, err, want)
+	}
+}
+
+func TestWriteManifestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), manifestFileName)
+	digests := map[string]string{
+		"b [x2].png":          strings.Repeat("2", 64),
+		"a [x1].jpg":          strings.Repeat("1", 64),
+		"spaces  in name.gif": strings.Repeat("3", 64),
+	}
+	if err := writeManifest(path, digests); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	if lines := strings.Split(string(b), "\n"); !strings.HasSuffix(lines[0], "  a [x1].jpg") {
+		t.Errorf("manifest isn't sorted by name:\n%s", b)
+	}
+	got, err := readManifest(path)
+	if err != nil || !reflect.DeepEqual(got, digests) {
+		t.Errorf("readManifest = %v, %v, want %v", got, err, digests)
+	}
+}
@@ -0,0 +1,83 @@
+// functions to read and write per-file JSON metadata sidecars
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const sidecarExt = ".json"
+
+// Response headers which are recorded in sidecar
+var metadataHeaders = []string{"Content-Type", "Content-Length", "Last-Modified", "ETag"}
+
+func sidecarName(filename string) string {
+	return filename + sidecarExt
+}
+
+// readMetadata returns the contents of sidecar of given media file,
+// or nil if it doesn't exist or can't be parsed.
+func readMetadata(filename string) map[string]any {
+	b, err := os.ReadFile(sidecarName(filename))
+	if err != nil {
+		return nil
+	}
+	metadata := map[string]any{}
+	if err := json.Unmarshal(b, &metadata); err != nil {
+		log("Cannot parse metadata of", quote(filename), err.Error())
+		return nil
+	}
+	return metadata
+}
+
+// downloadMetadata returns the rrip fields describing a completed download.
+// digest is SHA-256 of downloaded content, before post-processing or
+// embedding metadata, or empty string if it's not known.
+func downloadMetadata(n int64, header http.Header, digest string) map[string]any {
+	headers := map[string]string{}
+	for _, key := range metadataHeaders {
+		if value := header.Get(key); value != "" {
+			headers[key] = value
+		}
+	}
+	metadata := map[string]any{
+		"rrip_bytes":         n,
+		"rrip_download_time": time.Now().UTC().Format(time.RFC3339),
+		"rrip_headers":       headers,
+	}
+	if digest != "" {
+		metadata["rrip_sha256"] = digest
+	}
+	return metadata
+}
+
+// writeMetadata writes post data along with download fields to the sidecar
+// of filename. If download is nil (i.e post was seen again), the rrip fields
+// recorded in existing sidecar are retained, and only post data is refreshed.
+func writeMetadata(filename string, postDataMap map[string]any, download map[string]any) error {
+	metadata := map[string]any{}
+	if download == nil {
+		for key, value := range readMetadata(filename) {
+			if strings.HasPrefix(key, "rrip_") {
+				metadata[key] = value
+			}
+		}
+	}
+	for key, value := range postDataMap {
+		metadata[key] = value
+	}
+	for key, value := range download {
+		metadata[key] = value
+	}
+	metadata["rrip_updated_time"] = time.Now().UTC().Format(time.RFC3339)
+
+	return writeFileAtomic(sidecarName(filename), func(w io.Writer) error {
+		_, err := io.WriteString(w, marshallIndent(metadata))
+		return err
+	})
+}
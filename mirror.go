@@ -0,0 +1,82 @@
+// functions to keep target folder in sync with the traversed listing
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// IDs of posts in this run which passed filters, or were skipped for a
+// transient reason, so their existing files are kept
+var seenPostIds = map[string]bool{}
+
+// whether listing was traversed till the end, or till --max-files was
+// reached. Mirror removes files only if it was, otherwise files of posts
+// not reached yet would be removed.
+var traversalComplete bool
+
+// whether current directory is the target folder. In dry run,
+// target folder isn't created if it doesn't exist.
+var inTargetFolder bool
+
+// fileIds maps media files in folder to their post IDs, taken from
+// metadata sidecar or " [id]" suffix. Files whose ID is not known
+// are not included.
+func fileIds(folder string) (map[string]string, error) {
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return nil, err
+	}
+	ids := map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !isMediaFile(entry.Name()) {
+			continue
+		}
+		name := filepath.Join(folder, entry.Name())
+		id, _ := readMetadata(name)["id"].(string)
+		if id == "" {
+			id = idFromFileName(entry.Name())
+		}
+		if id != "" {
+			ids[name] = id
+		}
+	}
+	return ids, nil
+}
+
+// mirrorFolder removes files of posts which were not seen in this run.
+// Nothing is removed if more than --mirror-max-delete files would be.
+func mirrorFolder(folder string, dryRun bool) (int, error) {
+	ids, err := fileIds(folder)
+	if err != nil {
+		return 0, err
+	}
+	var remove []string
+	for name, id := range ids {
+		if !seenPostIds[id] {
+			remove = append(remove, name)
+		}
+	}
+	if len(remove) == 0 {
+		return 0, nil
+	}
+	if options.MirrorMaxDelete != -1 && len(remove) > options.MirrorMaxDelete {
+		eprintf("Mirror: %d files would be removed, which is more than --mirror-max-delete=%d. "+
+			"Not removing anything.\n", len(remove), options.MirrorMaxDelete)
+		return 0, nil
+	}
+	removed := 0
+	for _, name := range remove {
+		if dryRun {
+			eprintln("Mirror: would remove", quote(name))
+			continue
+		}
+		eprintln("Mirror: removing", quote(name))
+		if err := removeMediaFile(name); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
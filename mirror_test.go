@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMirrorFolder(t *testing.T) {
+	savedOptions, savedSeen := options, seenPostIds
+	t.Cleanup(func() { options, seenPostIds = savedOptions, savedSeen })
+
+	files := []string{
+		"kept [aaa].jpg",
+		"kept [aaa].jpg.json",
+		"gone [bbb].jpg",
+		"gone [bbb].jpg.json",
+		"renamed.png", // ID is taken from sidecar
+		"renamed.png.json",
+		"unknown.jpg", // ID is not known, never removed
+		"notes.txt",
+	}
+	setup := func() string {
+		dir := t.TempDir()
+		for _, name := range files {
+			content := ""
+			if name == "renamed.png.json" {
+				content = `{"id": "ccc"}`
+			}
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dir
+	}
+	list := func(dir string) []string {
+		entries, _ := os.ReadDir(dir)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	tests := []struct {
+		seen      []string
+		maxDelete int
+		dryRun    bool
+		removed   int
+		remaining []string
+	}{
+		{[]string{"aaa", "ccc"}, -1, false, 1, []string{
+			"kept [aaa].jpg", "kept [aaa].jpg.json", "notes.txt",
+			"renamed.png", "renamed.png.json", "unknown.jpg"}},
+		{[]string{"aaa"}, -1, false, 2, []string{
+			"kept [aaa].jpg", "kept [aaa].jpg.json", "notes.txt", "unknown.jpg"}},
+		{[]string{"aaa"}, -1, true, 0, nil},
+		{[]string{"zzz"}, 2, false, 0, nil},
+	}
+	for i, tt := range tests {
+		dir := setup()
+		seenPostIds = map[string]bool{}
+		for _, id := range tt.seen {
+			seenPostIds[id] = true
+		}
+		options.MirrorMaxDelete = tt.maxDelete
+		removed, err := mirrorFolder(dir, tt.dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed != tt.removed {
+			t.Errorf("%d: removed %d files, want %d", i, removed, tt.removed)
+		}
+		want := tt.remaining
+		if want == nil {
+			want = append([]string{}, files...)
+			sort.Strings(want)
+		}
+		if got := list(dir); !reflect.DeepEqual(got, want) {
+			t.Errorf("%d: files after mirror = %q, want %q", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// functions to detect near-duplicate images (resized / re-encoded reposts)
+// using difference hash (dHash) of image
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	flag "github.com/spf13/pflag"
+	"golang.org/x/image/draw"
+)
+
+const defaultPHashThreshold = 6
+
+// dHash computes 64 bit difference hash of img. Image is shrunk to 9x8
+// grayscale pixels, and each bit records whether a pixel is brighter than
+// its right neighbour. Similar images have hashes with small Hamming distance.
+func dHash(img image.Image) uint64 {
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y > small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func formatPHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// smallestPreview returns the lowest resolution reddit preview of the post,
+// which is enough for computing dHash.
+func smallestPreview(post PostData) *ImagePreviewEntry {
+	if len(post.Preview.Images) == 0 {
+		return nil
+	}
+	choices := post.Preview.Images[0]
+	if len(choices.Resolutions) > 0 {
+		return pickPreview(choices, choices.Resolutions[0].Width)
+	}
+	return pickPreview(choices, -1)
+}
+
+// fetchImage downloads and decodes the image at url
+func fetchImage(url string) (image.Image, error) {
+	response, err := GetUrl(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return nil, errors.New("cannot fetch image: " + response.Status)
+	}
+	img, _, err := image.Decode(response.Body)
+	return img, err
+}
+
+// fetchSmallestPreview downloads the smallest reddit preview of post
+func fetchSmallestPreview(post PostData) (image.Image, error) {
+	preview := smallestPreview(post)
+	if preview == nil {
+		return nil, errors.New("no preview found")
+	}
+	return fetchImage(html.UnescapeString(preview.Url))
+}
+
+// previewFetcher returns a function which fetches smallest preview
+// of post on first call, and returns the same result on later calls.
+func previewFetcher(post PostData) func() (image.Image, error) {
+	var img image.Image
+	var err error
+	fetched := false
+	return func() (image.Image, error) {
+		if !fetched {
+			img, err = fetchSmallestPreview(post)
+			fetched = true
+		}
+		return img, err
+	}
+}
+
+// PHashIndex stores dHash of downloaded files. Lookups are linear,
+// which is fast enough for hundreds of thousands of files.
+type PHashIndex struct {
+	hashes []uint64
+	paths  []string
+	file   *os.File
+}
+
+var phashIndex *PHashIndex
+
+func loadPHashIndex(path string) (*PHashIndex, error) {
+	index := &PHashIndex{}
+	var err error
+	index.file, err = openIndexFile(path, func(key, path string) {
+		hash, err := strconv.ParseUint(key, 16, 64)
+		if err == nil {
+			index.hashes = append(index.hashes, hash)
+			index.paths = append(index.paths, path)
+		}
+	})
+	return index, err
+}
+
+// Nearest returns an existing file whose hash is within threshold
+// distance of given hash, or empty string if there's none.
+func (index *PHashIndex) Nearest(hash uint64, threshold int) string {
+	bestPath, bestDistance := "", threshold+1
+	for i, other := range index.hashes {
+		distance := hammingDistance(hash, other)
+		if distance >= bestDistance {
+			continue
+		}
+		if _, err := os.Stat(index.paths[i]); err == nil {
+			bestPath, bestDistance = index.paths[i], distance
+		}
+	}
+	return bestPath
+}
+
+func (index *PHashIndex) Add(hash uint64, filename string) error {
+	path := absPath(filename)
+	index.hashes = append(index.hashes, hash)
+	index.paths = append(index.paths, path)
+	_, err := index.file.WriteString(formatPHash(hash) + "  " + path + "\n")
+	return err
+}
+
+// fileClusters groups files whose hashes are within threshold distance,
+// and returns groups having more than one file.
+func fileClusters(names []string, hashes []uint64, threshold int) [][]string {
+	// union-find over all pairs
+	parent := make([]int, len(names))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range hashes {
+		for j := i + 1; j < len(hashes); j++ {
+			if hammingDistance(hashes[i], hashes[j]) <= threshold {
+				parent[find(i)] = find(j)
+			}
+		}
+	}
+
+	groups := map[int][]string{}
+	for i, name := range names {
+		root := find(i)
+		groups[root] = append(groups[root], name)
+	}
+	var clusters [][]string
+	for _, group := range groups {
+		if len(group) > 1 {
+			sort.Strings(group)
+			clusters = append(clusters, group)
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i][0] < clusters[j][0]
+	})
+	return clusters
+}
+
+// dupesMain handles `rrip dupes <folder>`
+func dupesMain(args []string) {
+	flags := flag.NewFlagSet("dupes", flag.ExitOnError)
+	threshold := flags.Int("threshold", defaultPHashThreshold,
+		"Max Hamming distance (0-64) between hashes of similar images")
+	flags.Usage = func() {
+		eprintf("Usage: %s dupes <options> <folder>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || *threshold < 0 || *threshold > 64 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	folder := flags.Arg(0)
+
+	entries, err := os.ReadDir(folder)
+	check(err, "Cannot read folder")
+	var names []string
+	var hashes []uint64
+	for _, entry := range entries {
+		if entry.IsDir() || !isStillImage(entry.Name()) {
+			continue
+		}
+		img, _, err := decodeImageFile(filepath.Join(folder, entry.Name()))
+		if err != nil {
+			eprintln("Cannot decode", quote(entry.Name())+":", err.Error())
+			continue
+		}
+		names = append(names, entry.Name())
+		hashes = append(hashes, dHash(img))
+	}
+
+	clusters := fileClusters(names, hashes, *threshold)
+	for _, cluster := range clusters {
+		fmt.Println(horizontalDashedLine)
+		for _, name := range cluster {
+			fmt.Println(name)
+		}
+	}
+	if len(clusters) > 0 {
+		fmt.Println(horizontalDashedLine)
+	}
+	eprintf("%d images, %d clusters of similar images\n", len(names), len(clusters))
+}
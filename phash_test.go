@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFileClusters(t *testing.T) {
+	names := []string{"d", "a", "b", "c", "e"}
+	hashes := []uint64{
+		0xFF00, // d: 2 bits from a
+		0xFF03, // a
+		0xFF01, // b: 1 bit from a
+		0x00FF, // c: far from everything
+		0x00FE, // e: 1 bit from c
+	}
+	tests := []struct {
+		threshold int
+		want      [][]string
+	}{
+		{0, nil},
+		{1, [][]string{{"a", "b", "d"}, {"c", "e"}}},
+		{64, [][]string{{"a", "b", "c", "d", "e"}}},
+	}
+	for _, tt := range tests {
+		if got := fileClusters(names, hashes, tt.threshold); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("fileClusters(threshold=%d) = %v, want %v", tt.threshold, got, tt.want)
+		}
+	}
+}
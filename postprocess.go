@@ -0,0 +1,108 @@
+// functions to resize and recompress downloaded images
+
+package main
+
+import (
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultJPEGQuality = 90
+
+func needsPostProcessing() bool {
+	return options.ResizeWidth > 0 || options.JPEGQuality > 0 || options.PNGToJPEG
+}
+
+// convertedFileName returns the name of file after converting PNG to JPEG
+func convertedFileName(filename string) string {
+	ext := filepath.Ext(filename)
+	if strings.ToLower(ext) != ".png" {
+		return filename
+	}
+	return strings.TrimSuffix(filename, ext) + ".jpg"
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// postProcessImage resizes and / or recompresses a downloaded JPEG or PNG
+// according to options. Other files are left unchanged. Returns the name
+// of resulting file, which differs from filename if PNG was converted to JPEG.
+func postProcessImage(filename string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	isJPEG := ext == ".jpg" || ext == ".jpeg"
+	if !isJPEG && ext != ".png" {
+		return filename, nil
+	}
+
+	img, _, err := decodeImageFile(filename)
+	if err != nil {
+		return filename, err
+	}
+	resized := img
+	if options.ResizeWidth > 0 {
+		resized = resizeToFit(img, options.ResizeWidth, options.ResizeHeight)
+	}
+	wasResized := resized != img
+	convert := options.PNGToJPEG && !isJPEG && isOpaque(img)
+	recompress := isJPEG && options.JPEGQuality > 0
+	if !wasResized && !convert && !recompress {
+		return filename, nil
+	}
+
+	newName := filename
+	if convert {
+		newName = convertedFileName(filename)
+	}
+	output, err := createTemp(newName)
+	if err != nil {
+		return filename, err
+	}
+	tmp := output.Name()
+	if isJPEG || convert {
+		quality := defaultJPEGQuality
+		if options.JPEGQuality > 0 {
+			quality = options.JPEGQuality
+		}
+		err = jpeg.Encode(output, resized, &jpeg.Options{Quality: quality})
+	} else {
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(output, resized)
+	}
+	output.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return filename, err
+	}
+
+	// Recompressing an already well compressed JPEG can make it larger
+	if !wasResized && !convert {
+		original, err1 := os.Stat(filename)
+		recompressed, err2 := os.Stat(tmp)
+		if err1 == nil && err2 == nil && recompressed.Size() >= original.Size() {
+			log("Recompressed file is not smaller, keeping original")
+			return filename, os.Remove(tmp)
+		}
+	}
+
+	if err := os.Chmod(tmp, 0o644); err != nil {
+		os.Remove(tmp)
+		return filename, err
+	}
+	if err := os.Rename(tmp, newName); err != nil {
+		os.Remove(tmp)
+		return filename, err
+	}
+	if newName != filename {
+		return newName, os.Remove(filename)
+	}
+	return newName, nil
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"hash"
 	"io"
 	"time"
 )
@@ -8,6 +10,7 @@ import (
 // ProgressWriter wraps a io.Writer and an updater callback
 // It calls updater callback only if there are more than 1024 bytes written
 // And more than 500ms has elapsed since last call to callback
+// If Hash is not nil, everything written is also added to Hash
 
 const significantWrite = 1024 * 10
 const significantTime = 500
@@ -15,6 +18,7 @@ const significantTime = 500
 type ProgressWriter struct {
 	Callback  func(int64)
 	Writer    io.Writer
+	Hash      hash.Hash
 	total     int64
 	lastCall  int64
 	lastTotal int64
@@ -22,6 +26,9 @@ type ProgressWriter struct {
 
 func (pw *ProgressWriter) Write(p []byte) (n int, err error) {
 	n, err = pw.Writer.Write(p)
+	if pw.Hash != nil {
+		pw.Hash.Write(p[:n])
+	}
 	pw.total += int64(n)
 	var writeCondition, timeCondition bool
 	var now time.Time
@@ -38,3 +45,43 @@ func (pw *ProgressWriter) Write(p []byte) (n int, err error) {
 	}
 	return n, err
 }
+
+var errTooLarge = errors.New("size limit exceeded")
+
+// SizeLimitReader wraps a io.Reader and returns errTooLarge once more
+// than Limit bytes are read. Limit of -1 means no limit. Reason records
+// which limit is in effect, it's empty for per file size limit.
+type SizeLimitReader struct {
+	Reader io.Reader
+	Limit  int64
+	Reason string
+	read   int64
+}
+
+// Restrict lowers the limit to given value, if it's lower than current one
+func (lr *SizeLimitReader) Restrict(limit int64, reason string) {
+	if limit < 0 {
+		limit = 0
+	}
+	if lr.Limit == -1 || limit < lr.Limit {
+		lr.Limit = limit
+		lr.Reason = reason
+	}
+}
+
+func (lr *SizeLimitReader) Read(p []byte) (n int, err error) {
+	if lr.Limit == -1 {
+		return lr.Reader.Read(p)
+	}
+	// allow reading one byte more than limit, to detect crossing it
+	if remaining := lr.Limit + 1 - lr.read; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err = lr.Reader.Read(p)
+	if lr.read+int64(n) > lr.Limit {
+		n = int(lr.Limit - lr.read)
+		err = errTooLarge
+	}
+	lr.read += int64(n)
+	return n, err
+}
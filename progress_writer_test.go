@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSizeLimitReader(t *testing.T) {
+	tests := []struct {
+		size       int
+		limits     []int64
+		reasons    []string
+		wantErr    bool
+		wantReason string
+	}{
+		{size: 100, limits: nil, wantErr: false},
+		{size: 100, limits: []int64{100}, reasons: []string{""}, wantErr: false},
+		{size: 101, limits: []int64{100}, reasons: []string{""}, wantErr: true},
+		{size: 500, limits: []int64{1000, 200}, reasons: []string{"", "storage limit"},
+			wantErr: true, wantReason: "storage limit"},
+		{size: 500, limits: []int64{200, 1000}, reasons: []string{"", "storage limit"},
+			wantErr: true, wantReason: ""},
+		{size: 1, limits: []int64{-5}, reasons: []string{"free space limit"},
+			wantErr: true, wantReason: "free space limit"},
+	}
+	for i, tt := range tests {
+		lr := &SizeLimitReader{Reader: strings.NewReader(strings.Repeat("x", tt.size)), Limit: -1}
+		for j, limit := range tt.limits {
+			lr.Restrict(limit, tt.reasons[j])
+		}
+		n, err := io.Copy(io.Discard, lr)
+		if gotErr := errors.Is(err, errTooLarge); gotErr != tt.wantErr {
+			t.Errorf("%d: error = %v, want too large: %t", i, err, tt.wantErr)
+		}
+		if tt.wantErr && (lr.Reason != tt.wantReason || n != lr.Limit) {
+			t.Errorf("%d: read %d bytes with reason %q, want %d and %q",
+				i, n, lr.Reason, lr.Limit, tt.wantReason)
+		}
+		if !tt.wantErr && n != int64(tt.size) {
+			t.Errorf("%d: read %d bytes, want %d", i, n, tt.size)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// functions to remove downloaded files whose posts were deleted or
+// removed on reddit, or whose subreddit was banned
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// reddit returns at most this many posts for a single /api/info request
+const infoBatchSize = 100
+
+// fetchPostInfo fetches current data of posts with given IDs using
+// /api/info, and returns it keyed by post ID. Posts which reddit
+// doesn't return (eg: in banned or private subreddits) are missing.
+func fetchPostInfo(ids []string) (map[string]map[string]any, error) {
+	posts := map[string]map[string]any{}
+	for start := 0; start < len(ids); start += infoBatchSize {
+		batch := ids[start:min(start+infoBatchSize, len(ids))]
+		names := make([]string, len(batch))
+		for i, id := range batch {
+			names[i] = "t3_" + id
+		}
+		link := "https://www.reddit.com/api/info.json?id=" + url.QueryEscape(strings.Join(names, ","))
+		log("Request: ", link)
+		response, err := FetchUrlWithMethod(link, "GET", "application/json")
+		if err != nil {
+			return posts, err
+		}
+		var apiResponse struct {
+			Data struct {
+				Children []struct {
+					Data map[string]any
+				}
+			}
+		}
+		if response.StatusCode != 200 {
+			response.Body.Close()
+			return posts, errors.New("cannot fetch post info: " + response.Status)
+		}
+		err = json.NewDecoder(response.Body).Decode(&apiResponse)
+		response.Body.Close()
+		if err != nil {
+			return posts, err
+		}
+		for _, child := range apiResponse.Data.Children {
+			if id, ok := child.Data["id"].(string); ok {
+				posts[id] = child.Data
+			}
+		}
+	}
+	return posts, nil
+}
+
+// subredditBanned reports whether reddit says subreddit is banned
+func subredditBanned(subreddit string) (bool, error) {
+	response, err := FetchUrlWithMethod("https://www.reddit.com/r/"+subreddit+"/about.json",
+		"GET", "application/json")
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	var about struct{ Reason string }
+	if response.StatusCode == 200 {
+		return false, nil
+	}
+	if err := json.NewDecoder(response.Body).Decode(&about); err != nil {
+		return false, errors.New("cannot fetch subreddit info: " + response.Status)
+	}
+	return about.Reason == "banned", nil
+}
+
+// takedownReason returns why a post should no longer be kept, or
+// empty string if it's still available.
+func takedownReason(post map[string]any) string {
+	if category, ok := post["removed_by_category"].(string); ok && category != "" {
+		return "removed (" + category + ")"
+	}
+	if post["author"] == "[deleted]" {
+		return "deleted"
+	}
+	return ""
+}
+
+// moveFile renames from to to. If rename fails, eg: since they're on
+// different filesystems, from is copied (keeping its modification time)
+// and then removed. The cross device error differs between platforms,
+// and copying fails too for most other errors.
+func moveFile(from, to string) error {
+	err := os.Rename(from, to)
+	var linkErr *os.LinkError
+	if err == nil || !errors.As(err, &linkErr) {
+		return err
+	}
+	input, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	info, err := input.Stat()
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(to, func(w io.Writer) error {
+		_, err := io.Copy(w, input)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.Chtimes(to, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	input.Close()
+	return os.Remove(from)
+}
+
+// moveMediaFile moves a downloaded file along with its metadata
+// sidecar and thumbnail into folder
+func moveMediaFile(filename, folder string) error {
+	if err := os.MkdirAll(filepath.Join(folder, thumbsFolder), 0o755); err != nil {
+		return err
+	}
+	if err := moveFile(filename, filepath.Join(folder, filename)); err != nil {
+		return err
+	}
+	for _, companion := range []string{sidecarName(filename), thumbnailName(filename)} {
+		err := moveFile(companion, filepath.Join(folder, companion))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneMain handles `rrip prune <folder>`
+func pruneMain(args []string) {
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	dryRun := flags.BoolP("dry-run", "d", false, "Only print files which would be removed")
+	quarantine := flags.String("quarantine", "",
+		"Move files to this folder instead of deleting them")
+	flags.BoolVarP(&options.Debug, "verbose", "v", false, "Enable verbose output")
+	addNetworkFlags(flags)
+	config := addConfigFlags(flags)
+	flags.Usage = func() {
+		eprintf("Usage: %s prune <options> <folder>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	config.apply(flags)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	setupClients()
+	if *quarantine != "" {
+		*quarantine = absPath(*quarantine)
+	}
+	// file names are relative to folder, as are thumbnails
+	check(os.Chdir(flags.Arg(0)), "Cannot open folder")
+
+	files, err := fileIds(".")
+	check(err, "Cannot read folder")
+	names := make([]string, 0, len(files))
+	ids := make([]string, 0, len(files))
+	for name, id := range files {
+		names = append(names, name)
+		ids = append(ids, id)
+	}
+	sort.Strings(names)
+	posts, err := fetchPostInfo(ids)
+	check(err, "Cannot fetch post info")
+
+	banned := map[string]bool{}
+	reasons := map[string]int{}
+	unknown, failed := 0, 0
+	for _, name := range names {
+		var reason string
+		if post, ok := posts[files[name]]; ok {
+			reason = takedownReason(post)
+		} else {
+			subreddit, _ := readMetadata(name)["subreddit"].(string)
+			if _, checked := banned[subreddit]; subreddit != "" && !checked {
+				var err error
+				banned[subreddit], err = subredditBanned(subreddit)
+				if err != nil {
+					eprintln("Cannot check subreddit", quote(subreddit)+":", err.Error())
+				}
+			}
+			if !banned[subreddit] {
+				log("Post not found, keeping:", quote(name))
+				unknown++
+				continue
+			}
+			reason = "subreddit banned"
+		}
+		if reason == "" {
+			continue
+		}
+
+		action := "Removed"
+		var err error
+		switch {
+		case *dryRun:
+			action = "Would remove"
+		case *quarantine != "":
+			action = "Quarantined"
+			err = moveMediaFile(name, *quarantine)
+		default:
+			err = removeMediaFile(name)
+		}
+		if err != nil {
+			eprintln("Cannot remove", quote(name)+":", err.Error())
+			failed++
+			continue
+		}
+		fmt.Printf("%s %s: %s\n", action, reason, name)
+		reasons[reason]++
+	}
+
+	if _, err := os.Stat(manifestFileName); err == nil && !*dryRun {
+		check(updateManifest(".", false), "Cannot update manifest")
+	}
+
+	eprintf("%d files checked\n", len(files))
+	summary := make([]string, 0, len(reasons))
+	for reason := range reasons {
+		summary = append(summary, reason)
+	}
+	sort.Strings(summary)
+	for _, reason := range summary {
+		eprintf("%d %s\n", reasons[reason], reason)
+	}
+	if unknown > 0 {
+		eprintf("%d not found on reddit, kept\n", unknown)
+	}
+	if failed > 0 {
+		eprintf("%d could not be removed\n", failed)
+		os.Exit(1)
+	}
+}
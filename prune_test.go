@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveMediaFile(t *testing.T) {
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	name := "removed [abc].jpg"
+	os.MkdirAll(thumbsFolder, 0o755)
+	for _, file := range []string{name, sidecarName(name), thumbnailName(name)} {
+		if err := os.WriteFile(file, []byte(file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := moveMediaFile(name, "quarantine"); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{name, sidecarName(name), thumbnailName(name)} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("%q not moved", file)
+		}
+		b, err := os.ReadFile(filepath.Join("quarantine", file))
+		if err != nil || string(b) != file {
+			t.Errorf("moved %q = %q, %v", file, b, err)
+		}
+	}
+
+	// companions are optional, but the file isn't
+	other := "other [def].png"
+	os.WriteFile(other, nil, 0o644)
+	if err := moveMediaFile(other, "quarantine"); err != nil {
+		t.Errorf("file without companions: %v", err)
+	}
+	if err := moveMediaFile("missing [x].jpg", "quarantine"); !os.IsNotExist(err) {
+		t.Errorf("missing file: error = %v, want not exist", err)
+	}
+}
@@ -0,0 +1,153 @@
+// functions to limit the size of target folder, and to remove
+// old files according to a retention policy
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// size of target folder before this run
+var folderBytes int64
+
+// folderSize returns total size of files in folder, including subfolders
+func folderSize(folder string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(folder, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// RetentionPolicy decides which files are kept in target folder.
+// Kind is one of "newest", "days" or "top"
+type RetentionPolicy struct {
+	Kind  string
+	Count int
+}
+
+func parseRetention(s string) (RetentionPolicy, error) {
+	kind, count, ok := strings.Cut(s, ":")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || n < 1 {
+		return RetentionPolicy{}, errors.New("expected newest:N, days:D or top:N")
+	}
+	switch kind {
+	case "newest", "days", "top":
+		return RetentionPolicy{Kind: kind, Count: n}, nil
+	}
+	return RetentionPolicy{}, errors.New("unknown retention policy: " + quote(kind))
+}
+
+// removeMediaFile removes a downloaded file along with its
+// metadata sidecar and thumbnail
+func removeMediaFile(filename string) error {
+	if err := os.Remove(filename); err != nil {
+		return err
+	}
+	for _, companion := range []string{sidecarName(filename), thumbnailName(filename)} {
+		if err := os.Remove(companion); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+type retainedFile struct {
+	name     string
+	created  time.Time
+	score    float64
+	hasScore bool
+}
+
+// applyRetention removes media files in folder which aren't retained by
+// policy, and returns number of files removed. Only files named by rrip
+// (i.e with post ID suffix) are considered. Post time and score are
+// taken from metadata sidecars. Files without sidecar use modification
+// time, and are never removed by "top" policy since their score is unknown.
+func applyRetention(folder string, policy RetentionPolicy) (int, error) {
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return 0, err
+	}
+	var files []retainedFile
+	for _, entry := range entries {
+		if entry.IsDir() || !isMediaFile(entry.Name()) || idFromFileName(entry.Name()) == "" {
+			continue
+		}
+		file := retainedFile{name: filepath.Join(folder, entry.Name())}
+		metadata := readMetadata(file.name)
+		if metadata != nil {
+			file.created = postCreatedTime(metadata)
+			file.score, file.hasScore = metadata["score"].(float64)
+		}
+		if file.created.Unix() <= 0 {
+			info, err := entry.Info()
+			if err != nil {
+				return 0, err
+			}
+			file.created = info.ModTime()
+		}
+		files = append(files, file)
+	}
+
+	var remove []retainedFile
+	switch policy.Kind {
+	case "newest":
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].created.After(files[j].created)
+		})
+		if len(files) > policy.Count {
+			remove = files[policy.Count:]
+		}
+	case "days":
+		cutoff := time.Now().AddDate(0, 0, -policy.Count)
+		for _, file := range files {
+			if file.created.Before(cutoff) {
+				remove = append(remove, file)
+			}
+		}
+	case "top":
+		var scored []retainedFile
+		for _, file := range files {
+			if file.hasScore {
+				scored = append(scored, file)
+			} else {
+				log("Score unknown, not applying retention policy:", quote(file.name))
+			}
+		}
+		sort.Slice(scored, func(i, j int) bool {
+			return scored[i].score > scored[j].score
+		})
+		if len(scored) > policy.Count {
+			remove = scored[policy.Count:]
+		}
+	}
+
+	removed := 0
+	for _, file := range remove {
+		log("Removing due to retention policy:", quote(file.name))
+		if err := removeMediaFile(file.name); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
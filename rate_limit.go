@@ -0,0 +1,157 @@
+// token bucket rate limiting of downloads
+
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket shared by all downloads. Tokens are bytes,
+// and the bucket can go into debt, in which case readers sleep until
+// the debt is paid off at the current rate.
+type RateLimiter struct {
+	mu       sync.Mutex
+	tokens   float64
+	last     time.Time
+	rate     int64 // bytes per second, 0 for no limit
+	schedule []rateWindow
+}
+
+// rateWindow is a daily time window, in minutes since midnight,
+// during which a different rate applies
+type rateWindow struct {
+	start, end int
+	rate       int64
+}
+
+var rateLimiter *RateLimiter
+
+func (w rateWindow) contains(minute int) bool {
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	// window wraps around midnight
+	return minute >= w.start || minute < w.end
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseRateSchedule parses schedules like "08:00-18:00=200K,18:00-23:00=1M"
+func parseRateSchedule(schedule string) ([]rateWindow, error) {
+	var windows []rateWindow
+	for _, entry := range strings.Split(schedule, ",") {
+		span, rate, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, errors.New("expected <start>-<end>=<rate>, got " + quote(entry))
+		}
+		start, end, ok := strings.Cut(span, "-")
+		if !ok {
+			return nil, errors.New("expected <start>-<end>, got " + quote(span))
+		}
+		var w rateWindow
+		var err error
+		if w.start, err = parseClock(start); err != nil {
+			return nil, err
+		}
+		if w.end, err = parseClock(end); err != nil {
+			return nil, err
+		}
+		if w.rate, err = parseSize(rate); err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func NewRateLimiter(rate int64, schedule []rateWindow) *RateLimiter {
+	return &RateLimiter{rate: rate, schedule: schedule, last: time.Now()}
+}
+
+// Share returns a limiter with 1/n of the rates of rl, for when n
+// processes (eg: concurrent jobs of `rrip run`) share the limit.
+func (rl *RateLimiter) Share(n int) *RateLimiter {
+	share := func(rate int64) int64 {
+		if rate == 0 {
+			return 0
+		}
+		return max64(rate/int64(n), 1)
+	}
+	schedule := make([]rateWindow, len(rl.schedule))
+	for i, w := range rl.schedule {
+		schedule[i] = rateWindow{start: w.start, end: w.end, rate: share(w.rate)}
+	}
+	return NewRateLimiter(share(rl.rate), schedule)
+}
+
+// currentRate returns the rate of first schedule window containing
+// current time, or the default rate.
+func (rl *RateLimiter) currentRate(now time.Time) int64 {
+	minute := now.Hour()*60 + now.Minute()
+	for _, w := range rl.schedule {
+		if w.contains(minute) {
+			return w.rate
+		}
+	}
+	return rl.rate
+}
+
+// burst is the max number of bytes read in one go, and the max number
+// of tokens which can accumulate while idle.
+func burst(rate int64) int {
+	return max(int(rate/4), 4*1024)
+}
+
+// take consumes n tokens, and returns how long the caller should sleep
+func (rl *RateLimiter) take(n int) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	rate := rl.currentRate(now)
+	if rate == 0 {
+		rl.tokens, rl.last = 0, now
+		return 0
+	}
+	rl.tokens += now.Sub(rl.last).Seconds() * float64(rate)
+	if limit := float64(burst(rate)); rl.tokens > limit {
+		rl.tokens = limit
+	}
+	rl.last = now
+	rl.tokens -= float64(n)
+	if rl.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-rl.tokens / float64(rate) * float64(time.Second))
+}
+
+// RateLimitedReader wraps a reader (usually response body)
+// and limits read speed using a shared RateLimiter
+type RateLimitedReader struct {
+	Reader  io.Reader
+	Limiter *RateLimiter
+}
+
+func (r *RateLimitedReader) Read(p []byte) (int, error) {
+	rl := r.Limiter
+	rl.mu.Lock()
+	rate := rl.currentRate(time.Now())
+	rl.mu.Unlock()
+	if rate != 0 && len(p) > burst(rate) {
+		p = p[:burst(rate)]
+	}
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		time.Sleep(rl.take(n))
+	}
+	return n, err
+}
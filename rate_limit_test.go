@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRateSchedule(t *testing.T) {
+	got, err := parseRateSchedule("08:00-18:00=200K, 22:30-06:00=1M")
+	want := []rateWindow{
+		{start: 8 * 60, end: 18 * 60, rate: 200 * 1000},
+		{start: 22*60 + 30, end: 6 * 60, rate: 1000 * 1000},
+	}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRateSchedule = %v, %v, want %v", got, err, want)
+	}
+
+	for _, s := range []string{"", "08:00-18:00", "08:00=1M", "8-18=1M", "08:00-25:00=1M", "08:00-18:00=fast"} {
+		if got, err := parseRateSchedule(s); err == nil {
+			t.Errorf("parseRateSchedule(%q) = %v, want error", s, got)
+		}
+	}
+}
+
+func TestCurrentRate(t *testing.T) {
+	schedule, err := parseRateSchedule("08:00-18:00=200K,22:00-06:00=1M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := NewRateLimiter(500, schedule)
+	tests := []struct {
+		clock string
+		want  int64
+	}{
+		{"07:59", 500},
+		{"08:00", 200 * 1000},
+		{"17:59", 200 * 1000},
+		{"18:00", 500},
+		{"23:00", 1000 * 1000},
+		{"00:30", 1000 * 1000},
+		{"06:00", 500},
+	}
+	for _, tt := range tests {
+		now, _ := time.Parse("15:04", tt.clock)
+		if got := rl.currentRate(now); got != tt.want {
+			t.Errorf("currentRate(%s) = %d, want %d", tt.clock, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimiterShare(t *testing.T) {
+	schedule, err := parseRateSchedule("08:00-18:00=200K,22:00-06:00=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := NewRateLimiter(1000*1000, schedule).Share(4)
+	tests := []struct {
+		clock string
+		want  int64
+	}{
+		{"07:00", 250 * 1000},
+		{"12:00", 50 * 1000},
+		{"23:00", 0}, // no limit stays no limit
+	}
+	for _, tt := range tests {
+		now, _ := time.Parse("15:04", tt.clock)
+		if got := shared.currentRate(now); got != tt.want {
+			t.Errorf("currentRate(%s) = %d, want %d", tt.clock, got, tt.want)
+		}
+	}
+	if got := NewRateLimiter(3, nil).Share(4).rate; got != 1 {
+		t.Errorf("share of a small rate = %d, want 1", got)
+	}
+}
@@ -0,0 +1,229 @@
+// functions to rename existing files according to a new --filename-format
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+type renameStep struct {
+	from, to string
+}
+
+// renameMediaFile renames a downloaded file along with its metadata
+// sidecar and thumbnail, and records the new name in sidecar.
+func renameMediaFile(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	for _, names := range [][2]string{
+		{sidecarName(from), sidecarName(to)},
+		{thumbnailName(from), thumbnailName(to)},
+	} {
+		if err := os.Rename(names[0], names[1]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if metadata := readMetadata(to); metadata != nil {
+		metadata["rrip_filename"] = to
+		return writeMetadata(to, metadata, nil)
+	}
+	return nil
+}
+
+// newFileName returns new name of filename, given its post ID and
+// name formatted using --filename-format. Extension is kept as is.
+func newFileName(filename, id, formatted string) string {
+	return postFileName(formatted, id, filepath.Ext(filename))
+}
+
+// planRenames computes new names of files in current folder. Post data is
+// taken from sidecars, or fetched from reddit for files without one. If a
+// new name is already taken, " (2)", " (3)" etc.. is added before the ID.
+func planRenames(files map[string]string) (steps []renameStep, skipped []string, err error) {
+	names := make([]string, 0, len(files))
+	var missing []string
+	postData := map[string]map[string]any{}
+	for name, id := range files {
+		names = append(names, name)
+		if metadata := readMetadata(name); metadata != nil {
+			postData[name] = metadata
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	sort.Strings(names)
+
+	if len(missing) > 0 {
+		posts, err := fetchPostInfo(missing)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, name := range names {
+			if post, ok := posts[files[name]]; ok && postData[name] == nil {
+				postData[name] = post
+			}
+		}
+	}
+
+	// names which can't be used, i.e files which aren't renamed
+	// and new names already planned
+	taken := map[string]bool{}
+	entries, err := os.ReadDir(".")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, entry := range entries {
+		if _, ok := postData[entry.Name()]; !ok {
+			taken[entry.Name()] = true
+		}
+	}
+
+	// files which keep their names are handled first, so that
+	// other files aren't renamed to those names
+	formatted := map[string]string{}
+	var renamed []string
+	for _, name := range names {
+		postDataMap := postData[name]
+		if postDataMap == nil {
+			skipped = append(skipped, name)
+			continue
+		}
+		title, _ := postDataMap["title"].(string)
+		postDataMap["quoted_title"] = quote(title)
+		formatted[name] = formatTemplate(options.FilenameFormat, postDataMap)
+		if newFileName(name, files[name], formatted[name]) == name {
+			taken[name] = true
+		} else {
+			renamed = append(renamed, name)
+		}
+	}
+
+	for _, name := range renamed {
+		newName := newFileName(name, files[name], formatted[name])
+		for n := 2; taken[newName]; n++ {
+			newName = newFileName(name, files[name], fmt.Sprintf("%s (%d)", formatted[name], n))
+		}
+		taken[newName] = true
+		steps = append(steps, renameStep{from: name, to: newName})
+	}
+	return steps, skipped, nil
+}
+
+// applyRenames performs steps in an order such that no file is
+// overwritten. Cycles (eg: two files swapping names) are broken by
+// moving one of the files to a temporary name first.
+func applyRenames(steps []renameStep) error {
+	for len(steps) > 0 {
+		var pending []renameStep
+		for _, step := range steps {
+			if _, err := os.Lstat(step.to); err == nil {
+				pending = append(pending, step)
+				continue
+			}
+			if err := renameMediaFile(step.from, step.to); err != nil {
+				return err
+			}
+		}
+		if len(pending) == len(steps) {
+			// short name, since names can already be at the length limit
+			tmp, err := createTemp(pending[0].from)
+			if err != nil {
+				return err
+			}
+			tmp.Close()
+			if err := renameMediaFile(pending[0].from, tmp.Name()); err != nil {
+				os.Remove(tmp.Name())
+				return err
+			}
+			pending[0].from = tmp.Name()
+		}
+		steps = pending
+	}
+	return nil
+}
+
+// renameMain handles `rrip rename <folder>`
+func renameMain(args []string) {
+	flags := flag.NewFlagSet("rename", flag.ExitOnError)
+	dryRun := flags.BoolP("dry-run", "d", false, "Only print the new names")
+	yes := flags.BoolP("yes", "y", false, "Don't ask for confirmation before renaming")
+	hashIndexPath := flags.String("dedupe-index", defaultIndexPath("hashes"),
+		"SHA-256 index of --dedupe, in which paths of renamed files are updated")
+	phashIndexPath := flags.String("phash-index", defaultIndexPath("phashes"),
+		"Perceptual hash index, in which paths of renamed files are updated")
+	flags.BoolVarP(&options.Debug, "verbose", "v", false, "Enable verbose output")
+	fileNames := addFileNameFlags(flags)
+	addNetworkFlags(flags)
+	config := addConfigFlags(flags)
+	flags.Usage = func() {
+		eprintf("Usage: %s rename <options> <folder>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	config.apply(flags)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	fileNames.apply(flags)
+	setupClients()
+	*hashIndexPath, *phashIndexPath = absPath(*hashIndexPath), absPath(*phashIndexPath)
+	// file names are relative to folder, as are thumbnails
+	check(os.Chdir(flags.Arg(0)), "Cannot open folder")
+
+	files, err := fileIds(".")
+	check(err, "Cannot read folder")
+	steps, skipped, err := planRenames(files)
+	check(err, "Cannot fetch post info")
+
+	for _, name := range skipped {
+		eprintln("Post data not found, not renaming:", quote(name))
+	}
+	for _, step := range steps {
+		fmt.Printf("%s\n  -> %s\n", step.from, step.to)
+	}
+	eprintf("%d files checked, %d to be renamed\n", len(files), len(steps))
+	if *dryRun || len(steps) == 0 {
+		return
+	}
+	if !*yes {
+		eprint("Rename files? [y/N] ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+			return
+		}
+	}
+	check(applyRenames(steps), "Cannot rename files")
+	// indexes store absolute paths, and are shared across folders
+	renamedPaths := map[string]string{}
+	for _, step := range steps {
+		renamedPaths[absPath(step.from)] = absPath(step.to)
+	}
+	for _, path := range []string{*hashIndexPath, *phashIndexPath} {
+		if err := renameInIndexFile(path, renamedPaths); err != nil {
+			eprintln("Cannot update index", quote(path)+":", err.Error())
+		}
+	}
+	if digests, err := readManifest(manifestFileName); err == nil {
+		renamed := map[string]string{}
+		for _, step := range steps {
+			if digest, ok := digests[step.from]; ok {
+				renamed[step.to] = digest
+				delete(digests, step.from)
+			}
+		}
+		for name, digest := range renamed {
+			digests[name] = digest
+		}
+		check(writeManifest(manifestFileName, digests), "Cannot update manifest")
+	}
+	eprintf("Renamed %d files\n", len(steps))
+}
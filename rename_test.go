@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyRenames(t *testing.T) {
+	dir := t.TempDir()
+	// names at the length limit, swapping with each other
+	a := filepath.Join(dir, strings.Repeat("a", 240)+" [aaa].jpg")
+	b := filepath.Join(dir, strings.Repeat("b", 240)+" [bbb].jpg")
+	c := filepath.Join(dir, "c [ccc].jpg")
+	for _, name := range []string{a, b, c} {
+		if err := os.WriteFile(name, []byte(filepath.Base(name)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(sidecarName(name), []byte("{}"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	steps := []renameStep{{from: a, to: b}, {from: b, to: a}, {from: c, to: c + ".renamed.jpg"}}
+	if err := applyRenames(steps); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range steps {
+		content, err := os.ReadFile(step.to)
+		if err != nil || string(content) != filepath.Base(step.from) {
+			t.Errorf("content of %q = %q, %v, want %q", step.to, content, err, filepath.Base(step.from))
+		}
+		if _, err := os.Stat(sidecarName(step.to)); err != nil {
+			t.Errorf("sidecar not renamed: %v", err)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 6 {
+		t.Errorf("%d files in folder after rename, want 6", len(entries))
+	}
+}
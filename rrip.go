@@ -1,10 +1,14 @@
 package main
 
 import (
-	"crypto/tls"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
+	"image"
 	"io"
 	"net/http"
 	"net/url"
@@ -12,7 +16,6 @@ import (
 	"os/signal"
 	"regexp"
 	"strings"
-	"text/template"
 
 	flag "github.com/spf13/pflag"
 )
@@ -46,10 +49,6 @@ var downloadingFilename string
 // For that, we need to keep a reference for signal handler
 var outputFile *os.File
 
-// BugFix: with transparent HTTP/2, sometimes reddit servers send HTML instead of JSON
-// So create a custom client
-var client http.Client
-
 var falseValues = map[string]bool{"": true, "nil": true, "false": true, "0": true}
 
 func pickPreview(choices ImagePreview, width int) *ImagePreviewEntry {
@@ -66,20 +65,94 @@ func pickPreview(choices ImagePreview, width int) *ImagePreviewEntry {
 }
 
 func PrintStat() {
+	if options.ListOnly {
+		eprintf("Listed %d of %d posts\n", stats.Saved, stats.Processed)
+		return
+	}
 	eprintln(horizontalDashedLine)
+	if stats.StopReason != "" {
+		eprintln("Stopped:", stats.StopReason)
+	}
 	eprintln("Processed Posts: ", stats.Processed)
 	eprintln("Already Downloaded: ", stats.Repeated)
 	eprintln("Failed: ", stats.Failed)
 	eprintln("Saved: ", stats.Saved)
+	if options.Dedupe != "off" {
+		eprintln("Duplicates: ", stats.Duplicates)
+	}
+	if options.PHashThreshold != -1 {
+		eprintln("Near Duplicates: ", stats.NearDuplicates)
+	}
 	eprintln("Other: ",
-		stats.Processed-stats.Failed-stats.Repeated-stats.Saved)
+		stats.Processed-stats.Failed-stats.Repeated-stats.Saved-
+			stats.Duplicates-stats.NearDuplicates)
 	eprintln(horizontalDashedLine)
-	eprintln("Approx. Storage Used:", size(stats.CopiedBytes))
+	if options.EstimateSize {
+		eprintln("Estimated Size:", size(stats.EstimatedBytes))
+	}
+	eprintln("Approx. Data Downloaded:", size(stats.CopiedBytes))
+	eprintln("Storage Used:", size(stats.StoredBytes))
+	if options.FolderQuota != -1 {
+		eprintf("Folder Size: %s / %s\n", size(folderBytes+stats.StoredBytes),
+			size(options.FolderQuota))
+	}
+	if options.Retention != nil {
+		eprintln("Removed by Retention Policy:", stats.Pruned)
+	}
+	if options.Mirror {
+		eprintln("Removed by Mirror:", stats.Mirrored)
+	}
 	eprintln(horizontalDashedLine)
 }
 
+// writeStatsFile writes stats to --stats-json file, if given. It's called
+// when run ends in any way (including errors), since `rrip run` reads it.
+func writeStatsFile() {
+	if options.StatsFile == "" {
+		return
+	}
+	if err := os.WriteFile(options.StatsFile, []byte(marshallIndent(stats)), 0o644); err != nil {
+		eprintln("Cannot write stats:", err.Error())
+	}
+}
+
 func Finish() {
+	if options.Mirror && inTargetFolder {
+		// listing may not be traversed completely if a limit was hit
+		if !traversalComplete {
+			eprintln("Mirror: not removing files since run stopped before the end of listing")
+		} else if len(seenPostIds) == 0 {
+			eprintln("Mirror: no posts seen, not removing anything")
+		} else {
+			removed, err := mirrorFolder(".", options.DryRun || options.MirrorDryRun)
+			if err != nil {
+				eprintln("Cannot mirror folder:", err.Error())
+			}
+			stats.Mirrored += removed
+		}
+	}
+	if options.Retention != nil && !options.DryRun {
+		removed, err := applyRetention(".", *options.Retention)
+		if err != nil {
+			eprintln("Cannot apply retention policy:", err.Error())
+		}
+		stats.Pruned += removed
+	}
+	if manifestFile != nil {
+		manifestFile.Close()
+		if err := updateManifest(".", true); err != nil {
+			eprintln("Cannot update manifest:", err.Error())
+		}
+	}
 	PrintStat()
+	writeStatsFile()
+	if options.WriteGallery && !options.DryRun {
+		if err := writeGallery(".", defaultGalleryPageSize); err != nil {
+			eprintln("Cannot write gallery:", err.Error())
+		} else {
+			eprintln("Gallery written to", galleryFileName)
+		}
+	}
 	// This seems to fix partial printing with -print-post-data
 	os.Stderr.Close()
 	completion <- true
@@ -164,14 +237,7 @@ func CheckAndResolveImage(linkString string) (finalLink string, extension string
 
 // pass acceptMimeType = "" if no restriction
 func FetchUrlWithMethod(url, method string, acceptMimeType string) (*http.Response, error) {
-	req, err := http.NewRequest(method, url, nil)
-	check(err)
-	req.Header.Add("User-Agent", options.UserAgent)
-
-	if acceptMimeType != "" {
-		req.Header.Add("Accept", acceptMimeType)
-	}
-
+	req := newRequest(context.Background(), method, url, acceptMimeType)
 	response, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -183,6 +249,22 @@ func GetUrl(url string) (*http.Response, error) {
 	return FetchUrlWithMethod(url, "GET", "")
 }
 
+// headContentLength returns size of the file at url using a HEAD request,
+// or -1 if it's not known. Some hosts reject HEAD requests entirely.
+func headContentLength(url string) int64 {
+	response, err := FetchUrlWithMethod(url, "HEAD", "")
+	if err != nil {
+		log("HEAD request failed:", err.Error())
+		return -1
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		log("HEAD request failed:", response.Status)
+		return -1
+	}
+	return response.ContentLength
+}
+
 func Traverse(path string, handler PostHandler) {
 	query := url.Values{}
 
@@ -201,10 +283,10 @@ func Traverse(path string, handler PostHandler) {
 	switch options.Sort {
 	case "hot", "new", "rising":
 		sortString = options.Sort
-	case "top-hour", "top-day", "top-month", "top-year", "top-all":
+	case "top-hour", "top-day", "top-week", "top-month", "top-year", "top-all":
 		sortString = "top"
 		timePeriod = strings.TrimPrefix(options.Sort, "top-")
-	case "":
+	case "", "best":
 		_ = "best" // do nothing
 	default:
 		fatal("Invalid option passed to sort")
@@ -244,6 +326,7 @@ func Traverse(path string, handler PostHandler) {
 		processed := stats.Processed
 		after = HandlePosts(response.Body, handler)
 		if stats.Processed == processed {
+			traversalComplete = true
 			Finish()
 		}
 		response.Body.Close()
@@ -266,64 +349,101 @@ func chooseByRegexMatch(re *regexp.Regexp, s string) bool {
 	return true
 }
 
-func DownloadPost(post PostData, postDataMap map[string]any) {
-	title := strings.TrimSpace(strings.ReplaceAll(post.Title, "/", "|"))
-	title = html.UnescapeString(title) // &amp; etc.. are escaped in json
-	if len(title) > 194 {
-		title = title[:192] + ".."
-	}
-
+// passesFilters reports whether post passes regex, score, colour and
+// template filters. Fields used by templates are added to postDataMap.
+// getSmallPreview is used to fetch preview for colour filters.
+func passesFilters(post PostData, postDataMap map[string]any,
+	getSmallPreview func() (image.Image, error)) bool {
 	if !chooseByRegexMatch(options.TitleContains, post.Title) {
 		log("Title not match regex:", quote(post.Title))
-		return
+		return false
 	}
 
 	if !chooseByRegexMatch(options.FlairContains, post.LinkFlairText) {
 		log("Flair not match regex:", quote(post.Title), quote(post.LinkFlairText))
-		return
+		return false
 	}
 
 	if !chooseByRegexMatch(options.LinkContains, post.Url) {
 		log("Link not match regex:", quote(post.Title), post.Url)
-		return
+		return false
 	}
 
 	if skipByRegexMatch(options.TitleNotContains, post.Title) {
 		log("Title skipped by regex: ", quote(post.Title))
-		return
+		return false
 	}
 
 	if skipByRegexMatch(options.FlairNotContains, post.LinkFlairText) {
 		log("Flair skipped by regex: ", quote(post.Title), quote(post.LinkFlairText))
-		return
+		return false
 	}
 
 	if skipByRegexMatch(options.LinkNotContains, post.Url) {
 		log("Posted link skipped by regex: ", quote(post.Title), post.Url)
-		return
+		return false
 	}
 
 	if post.Score < options.MinScore {
-		log("Skipped due to less score:", title,
+		log("Skipped due to less score:", quote(post.Title),
 			"| Score:", post.Score, "|", post.Url, "\n")
 		if strings.HasPrefix(options.Sort, "top-") {
 			eprintln("Skipping posts with less points, since sort=" + options.Sort)
+			// rest of the listing has even less points
+			traversalComplete = true
 			Finish()
 		}
-		return
+		return false
 	}
 
 	postDataMap["quoted_title"] = quote(post.Title)
 	postDataMap["final_url"] = "![will be set after processing]"
 	postDataMap["rrip_filename"] = "![will be set after processing]"
 
+	if options.ColorStats {
+		img, err := getSmallPreview()
+		if err != nil {
+			log("Cannot get preview for color filters:", quote(post.Title), err.Error())
+			// can't tell whether it passes the filters
+			if hasColorFilters() {
+				// keep existing file of the post for --mirror
+				seenPostIds[post.Id] = true
+				return false
+			}
+		} else {
+			cs := computeColorStats(img)
+			postDataMap["rrip_luminance"] = cs.Luminance
+			postDataMap["rrip_black_ratio"] = cs.BlackRatio
+			postDataMap["rrip_dominant_color"] = cs.DominantColor
+			postDataMap["rrip_dominant_color_hex"] = cs.DominantHex
+			if reason := colorFilterReason(cs); reason != "" {
+				log(reason+":", quote(post.Title))
+				return false
+			}
+		}
+	}
+
 	if options.TemplateFilter != nil {
 		templated := formatTemplate(options.TemplateFilter, postDataMap)
 		if falseValues[templated] {
 			log("template filter evaluated to:", quote(templated))
-			return
+			return false
 		}
 	}
+	return true
+}
+
+func DownloadPost(post PostData, postDataMap map[string]any) {
+	title := strings.TrimSpace(strings.ReplaceAll(post.Title, "/", "|"))
+	title = html.UnescapeString(title) // &amp; etc.. are escaped in json
+
+	// small reddit preview, fetched at most once for colour filters
+	// and perceptual hash
+	getSmallPreview := previewFetcher(post)
+	if !passesFilters(post, postDataMap, getSmallPreview) {
+		return
+	}
+	seenPostIds[post.Id] = true
 
 	// Print post data only if its not already excluded by a template / regex
 	// filter.
@@ -364,9 +484,8 @@ func DownloadPost(post PostData, postDataMap map[string]any) {
 		return
 	}
 
-	filenameRaw := formatTemplate(options.FilenameFormat, postDataMap)
-	filename := fmt.Sprintf("%s [%s]%s", filenameRaw, post.Id, extension)
-	filename = sanitizeFileName(filename, options.AllowSpecialChars)
+	filename := postFileName(formatTemplate(options.FilenameFormat, postDataMap),
+		post.Id, extension)
 	log("URL: ", url, " | Score:", post.Score)
 	if imageUrl != url {
 		log("->", imageUrl)
@@ -375,10 +494,7 @@ func DownloadPost(post PostData, postDataMap map[string]any) {
 	postDataMap["rrip_filename"] = filename
 	postDataMap["final_url"] = imageUrl
 
-	if options.DataOutputFile != nil && options.DataOutputFormat != nil {
-		fmt.Fprintln(options.DataOutputFile,
-			formatTemplate(options.DataOutputFormat, postDataMap))
-	}
+	writeDataOutput(postDataMap)
 
 	printName := func() {
 		eprintf("\r%-*.*s", terminalColumns-24, terminalColumns-24,
@@ -389,23 +505,79 @@ func DownloadPost(post PostData, postDataMap map[string]any) {
 
 	// check if already downloaded file
 	_, err := os.Stat(filename)
+	if err != nil && options.PNGToJPEG {
+		// PNG might have been converted to JPEG after download
+		if _, convErr := os.Stat(convertedFileName(filename)); convErr == nil {
+			filename, err = convertedFileName(filename), nil
+			postDataMap["rrip_filename"] = filename
+		}
+	}
 	if err == nil {
 		eprint("    [Already Saved]\n")
 		stats.Repeated += 1
+		if options.WriteMetadata && !options.DryRun {
+			if err := writeMetadata(filename, postDataMap, nil); err != nil {
+				eprintln("Cannot update metadata:", err.Error())
+			}
+		}
+		if options.ThumbnailSize > 0 && !options.DryRun {
+			if _, err := os.Stat(thumbnailName(filename)); os.IsNotExist(err) {
+				if err := makeThumbnail(filename, post); err != nil {
+					eprintln("Cannot create thumbnail:", err.Error())
+				}
+			}
+		}
 		return
 	}
 
 	// If dry run, don't fetch media, or create a file
 	// but you still have to increase number of files for config.MaxFiles to work
 	if options.DryRun {
-		eprint("    [Dry Run]\n")
+		if options.EstimateSize {
+			length := headContentLength(imageUrl)
+			if length > 0 {
+				stats.EstimatedBytes += length
+			}
+			eprintf("    [Dry Run: %s]\n", size(length))
+		} else {
+			eprint("    [Dry Run]\n")
+		}
 		stats.Saved += 1
 		if stats.Saved == options.MaxFiles {
+			traversalComplete = true
 			Finish()
 		}
 		return
 	}
 
+	// Duplicates deleted in an earlier run aren't downloaded again
+	if hashIndex != nil {
+		if original := hashIndex.LookupDeleted(filename); original != "" {
+			stats.Duplicates += 1
+			eprint("    [Duplicate]\n")
+			log("Duplicate of:", original)
+			return
+		}
+	}
+
+	// Check for near duplicates using the small reddit preview,
+	// before spending bandwidth on the full file
+	var phash uint64
+	hasPHash := false
+	if phashIndex != nil {
+		if img, err := getSmallPreview(); err != nil {
+			log("Cannot compute perceptual hash from preview:", err.Error())
+		} else {
+			phash, hasPHash = dHash(img), true
+			if similar := phashIndex.Nearest(phash, options.PHashThreshold); similar != "" {
+				stats.NearDuplicates += 1
+				eprint("    [Near Duplicate]\n")
+				log("Similar to:", similar)
+				return
+			}
+		}
+	}
+
 	// CHECK: any edge case?
 	var output *os.File = nil // don't create until needed
 
@@ -422,39 +594,61 @@ func DownloadPost(post PostData, postDataMap map[string]any) {
 			}
 		}
 	}
-	// Fetch
-	response, err := FetchUrlWithMethod(imageUrl, "HEAD", "")
+	// Fetch. Headers are checked before reading the body, and if the file
+	// is rejected, body is closed without reading.
+	fullResponse, err := FetchMedia(imageUrl)
 	if err != nil {
 		netError("Request ")
 		return
 	}
-	defer response.Body.Close()
+	defer fullResponse.Body.Close()
+
+	if fullResponse.StatusCode != http.StatusOK {
+		stats.Failed += 1
+		eprintln("    [HTTP Error: " + fullResponse.Status + "]")
+		return
+	}
 
 	// check content-type
 	// It's generally rare, but few sites send html from urls that end with gif etc..
-	contentType := response.Header.Get("Content-Type")
+	contentType := fullResponse.Header.Get("Content-Type")
 	if !strings.HasPrefix(contentType, "image/") &&
 		!strings.HasPrefix(contentType, "video/") {
 		eprintln("    [Unexpected Content-Type: " + contentType + "]")
 		return
 	}
 
-	length := response.ContentLength
-	// If larger or unknown length, skip
-	skipDueToSize := (options.MaxSize != -1) &&
-		(options.MaxSize < length || length == -1)
-	// if file length unknown and there is storage limit, skip
-	skipDueToSize = skipDueToSize ||
-		(options.MaxStorage != -1 && length == -1)
-	if skipDueToSize {
+	length := fullResponse.ContentLength
+	// If larger, skip. Files of unknown length are checked while streaming
+	if options.MaxSize != -1 && options.MaxSize < length {
 		eprintf("    [Too Large: %s]\n", size(length))
 		return
 	}
 	// if file length will go past the storage limit, finish
 	if options.MaxStorage != -1 && options.MaxStorage < length+stats.CopiedBytes {
 		eprintf("    [%s | Crosses storage limit]\n\n", size(length))
+		stats.StopReason = "Reached storage limit"
 		Finish()
 	}
+	if options.FolderQuota != -1 &&
+		options.FolderQuota < length+folderBytes+stats.StoredBytes {
+		eprintf("    [%s | Crosses folder quota]\n\n", size(length))
+		stats.StopReason = "Folder quota reached"
+		Finish()
+	}
+	// if free space would fall below the limit, finish
+	free := int64(-1)
+	if options.MinFreeSpace != -1 {
+		var err error
+		if free, err = diskFree("."); err != nil {
+			log("Cannot check free space:", err.Error())
+			free = -1
+		} else if free-max64(length, 0) < options.MinFreeSpace {
+			eprintf("    [%s | Not enough free space]\n\n", size(length))
+			stats.StopReason = fmt.Sprintf("Free space (%s) is below --min-free-space", size(free))
+			Finish()
+		}
+	}
 
 	// Create file
 	downloadingFilename = filename
@@ -481,147 +675,377 @@ func DownloadPost(post PostData, postDataMap map[string]any) {
 		_n, _ := eprintf("%-*s", maxCharsOnRight, progress)
 		maxCharsOnRight = max(_n, maxCharsOnRight)
 	}}
-
-	// do a GET request
-	fullResponse, err := GetUrl(imageUrl)
-	if err != nil {
-		netError("Request ")
-		return
+	if hashIndex != nil || manifestFile != nil || options.WriteMetadata {
+		out.Hash = sha256.New()
 	}
-	defer fullResponse.Body.Close()
 
-	n, err := io.Copy(&out, fullResponse.Body)
+	var body io.Reader = fullResponse.Body
+	// enforce size limits while streaming too, since length can be unknown
+	limit := &SizeLimitReader{Reader: body, Limit: -1}
+	if options.MaxSize != -1 {
+		limit.Restrict(options.MaxSize, "")
+	}
+	if options.MaxStorage != -1 {
+		limit.Restrict(options.MaxStorage-stats.CopiedBytes, "storage limit")
+	}
+	if options.FolderQuota != -1 {
+		limit.Restrict(options.FolderQuota-folderBytes-stats.StoredBytes, "folder quota")
+	}
+	if free != -1 {
+		limit.Restrict(free-options.MinFreeSpace, "free space limit")
+	}
+	if limit.Limit != -1 {
+		body = limit
+	}
+	if rateLimiter != nil {
+		body = &RateLimitedReader{Reader: body, Limiter: rateLimiter}
+	}
+	n, err := io.Copy(&out, body)
 	printName()
 	// add n to how much diskspace is consumed even if there's an error
 	// because it would give a more appropriate approximation of bandwidth consumption
 	// But if you're using that option to limit data usage, give 80% of airtime you can use
 	stats.CopiedBytes += n
 
+	if errors.Is(err, errTooLarge) {
+		output.Close()
+		log("Try remove file: ", filename)
+		if rmErr := os.Remove(filename); rmErr != nil {
+			log("Error removing file")
+		}
+		if limit.Reason != "" {
+			eprintf("    [Too Large: %s | Crosses %s]\n\n", size(n), limit.Reason)
+			stats.StopReason = "Reached " + limit.Reason
+			Finish()
+		}
+		eprintf("    [Too Large: more than %s]\n", size(n))
+		return
+	}
+
 	if err != nil {
 		netError("Transfer ")
 		return
 	}
 
 	// Transfer success I hope
+	// file has to be closed before it can be replaced on windows
+	output.Close()
+	// file is complete, don't remove it if interrupted while post processing
+	outputFile = nil
+	downloadingFilename = ""
+
+	var digest string
+	if out.Hash != nil {
+		digest = hex.EncodeToString(out.Hash.Sum(nil))
+	}
+	if hashIndex != nil {
+		if original := hashIndex.Lookup(digest, filename); original != "" {
+			stats.Duplicates += 1
+			done := fmt.Sprintf("    [Duplicate: %s]\n", size(n))
+			eprintf("%-*s", maxCharsOnRight, done)
+			log("Duplicate of:", original)
+			if err := dedupeFile(filename, original); err != nil {
+				eprintln("Cannot " + options.Dedupe + " duplicate file: " + err.Error())
+			} else if options.Dedupe == "delete" {
+				if err := hashIndex.AddDeleted(digest, filename); err != nil {
+					eprintln("Cannot update hash index:", err.Error())
+				}
+			} else {
+				if options.WriteMetadata {
+					err = writeMetadata(filename, postDataMap,
+						downloadMetadata(n, fullResponse.Header, digest))
+					if err != nil {
+						eprintln("Cannot write metadata:", err.Error())
+					}
+				}
+				// original may have been changed by post processing
+				// or embedding metadata since it was downloaded
+				if manifestFile != nil {
+					if digest, err := hashFile(filename); err != nil {
+						eprintln("Cannot hash file for manifest:", err.Error())
+					} else {
+						addToManifest(digest, filename)
+					}
+				}
+			}
+			return
+		}
+	}
+
 	// write stats
 	done := fmt.Sprintf("    [Complete: %s]\n", size(n))
 	eprintf("%-*s", maxCharsOnRight, done)
+	filename = processDownloadedFile(filename, post, postDataMap,
+		downloadMetadata(n, fullResponse.Header, digest))
+	if info, err := os.Stat(filename); err == nil {
+		stats.StoredBytes += info.Size()
+	}
+	if hashIndex != nil {
+		if err := hashIndex.Add(digest, filename); err != nil {
+			eprintln("Cannot update hash index:", err.Error())
+		}
+	}
+	if manifestFile != nil {
+		// file is changed by post processing and embedding metadata
+		if needsPostProcessing() || options.EmbedMetadata {
+			var err error
+			if digest, err = hashFile(filename); err != nil {
+				eprintln("Cannot hash file for manifest:", err.Error())
+			}
+		}
+		if digest != "" {
+			addToManifest(digest, filename)
+		}
+	}
+	if phashIndex != nil && !hasPHash && isStillImage(filename) {
+		if img, _, err := decodeImageFile(filename); err == nil {
+			phash, hasPHash = dHash(img), true
+		}
+	}
+	if hasPHash {
+		if err := phashIndex.Add(phash, filename); err != nil {
+			eprintln("Cannot update perceptual hash index:", err.Error())
+		}
+	}
 	stats.Saved += 1
 	if stats.Saved == options.MaxFiles {
+		// requested number of posts is traversed
+		traversalComplete = true
 		Finish()
 	}
 }
 
-func createLinksFile(filename string) io.WriteCloser {
+// processDownloadedFile runs optional steps after a file is downloaded
+// completely, and returns final name of the file.
+func processDownloadedFile(filename string, post PostData,
+	postDataMap map[string]any, download map[string]any) string {
+	if needsPostProcessing() {
+		processed, err := postProcessImage(filename)
+		if err != nil {
+			eprintln("Cannot resize / recompress image:", err.Error())
+		}
+		filename = processed
+		postDataMap["rrip_filename"] = filename
+	}
+	if options.EmbedMetadata {
+		err := embedMetadata(filename, newEmbeddedMetadata(post, postDataMap))
+		if err != nil {
+			eprintln("Cannot embed metadata:", err.Error())
+		}
+	}
+	if options.ThumbnailSize > 0 {
+		if err := makeThumbnail(filename, post); err != nil {
+			eprintln("Cannot create thumbnail:", err.Error())
+		}
+	}
+	if options.WriteMetadata {
+		if err := writeMetadata(filename, postDataMap, download); err != nil {
+			eprintln("Cannot write metadata:", err.Error())
+		}
+	}
+	return filename
+}
+
+// createLinksFile opens the data output file, and returns whether it
+// already had some content (only possible when appending).
+func createLinksFile(filename string, appendMode bool) (io.WriteCloser, bool) {
 	if filename == "" {
-		return nil
+		return nil, false
 	}
 	if filename == "-" || filename == "stdout" {
-		return os.Stdout
+		return os.Stdout, false
+	}
+	if !appendMode {
+		output, err := os.Create(filename)
+		check(err)
+		return output, false
 	}
-	output, err := os.Create(filename)
+	output, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
 	check(err)
-	return output
+	info, err := output.Stat()
+	check(err)
+	return output, info.Size() > 0
+}
+
+// commands which are invoked as `rrip <command> <args...>`
+var subcommands = map[string]func(args []string){
+	"download": downloadMain,
+	"list":     listMain,
+	"info":     infoMain,
+	"stats":    statsMain,
+	"gallery":  galleryMain,
+	"dupes":    dupesMain,
+	"prune":    pruneMain,
+	"rename":   renameMain,
+	"verify":   verifyMain,
+	"run":      runMain,
 }
 
 func main() {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if command, ok := subcommands[args[0]]; ok {
+			command(args[1:])
+			return
+		}
+	}
+	// download is the default, so that `rrip <options> r/sub` works
+	downloadMain(args)
+}
+
+const usage = `Usage: %[1]s [download] <options> <r/subreddit>...
+       %[1]s list <options> <r/subreddit>...
+       %[1]s info <options> <post>
+       %[1]s run <options> <jobs.yaml>
+       %[1]s stats <options> <folder>
+       %[1]s verify <options> <folder>
+       %[1]s prune <options> <folder>
+       %[1]s rename <options> <folder>
+       %[1]s gallery <options> <folder>
+       %[1]s dupes <options> <folder>
+
+Run '%[1]s <command> --help' for options of a command.
+
+Options of download:
+`
+
+// downloadMain handles `rrip download <r/subreddit>`
+func downloadMain(args []string) {
+	flags := flag.NewFlagSet("download", flag.ExitOnError)
 	help := false
 	// whether help option is provided
-	flag.BoolVar(&help, "help", false, "Show this help message")
+	flags.BoolVar(&help, "help", false, "Show this help message")
 	var dataOutputFileName string
 	var err error
-	var titleContains, titleNotContains string
-	var flairContains, flairNotContains string
-	var linkContains, linkNotContains string
-	var dataOutputFormat, templateFilter, filenameFormat string
+	var dataOutputFormat string
+	var dataOutputAppend bool
+	var resizeMax, hashIndexPath, phashIndexPath string
+	var limitRate, limitRateSchedule string
+	var limitRateShare int
+	var folderQuota, retention, minFreeSpace string
 
 	// option parsing
-	flag.BoolVarP(&options.Debug, "verbose", "v", false, "Enable verbose output (devel)")
-	flag.BoolVarP(&options.DryRun, "dry-run", "d", false, "DryRun i.e just print urls and names (devel)")
-	flag.BoolVar(&options.UseHTTP1, "http1", false, "Use HTTP/1.1 to make calls to Reddit API")
-	flag.BoolVar(&options.AllowSpecialChars, "allow-special-chars", false,
-		"Allow all characters in filenames except / and \\, "+
-			"And windows-special filenames like NUL")
-	flag.BoolVarP(&options.PrintPostData, "print-post-data", "P", false, "Print posts data as JSON. Implies dry run")
-	flag.StringVar(&options.After, "after", "", "Get posts after the given ID")
-	flag.StringVarP(&options.UserAgent, "useragent", "U", UserAgent, "UserAgent string")
-	flag.Int64Var(&options.MaxStorage, "max-storage", -1, "Data usage limit in MB, -1 for no limit")
-	flag.Int64VarP(&options.MaxSize, "max-size", "z", -1, "Max size of media file in KB, -1 for no limit")
-	flag.StringVar(&options.Folder, "folder", "", "Target folder name")
-
-	flag.StringVarP(&dataOutputFileName, "data-output-file", "O", "", "Log media links to given file")
-	flag.StringVarP(&dataOutputFormat, "data-output-format", "f", defaultDataOutputFormat, "Template for saving post data")
-	flag.StringVar(&templateFilter, "template-filter", "", "Posts will be ignored if this template evaluates to \"false\", \"0\" or empty string")
-	flag.StringVarP(&filenameFormat, "filename-format", "t", defaultFileNameFormat, "Template for naming files. (Post ID is always appended)")
-
-	flag.StringVar(&options.OgType, "og-type", "", "Look Up for a media link in page's og:property"+
+	flags.BoolVarP(&options.Debug, "verbose", "v", false, "Enable verbose output (devel)")
+	flags.BoolVarP(&options.DryRun, "dry-run", "d", false, "DryRun i.e just print urls and names (devel)")
+	listing := addListingFlags(flags)
+	config := addConfigFlags(flags)
+	fileNames := addFileNameFlags(flags)
+	flags.BoolVar(&options.EstimateSize, "estimate-size", false,
+		"With dry run, get size of each file using a HEAD request")
+	flags.BoolVarP(&options.PrintPostData, "print-post-data", "P", false, "Print posts data as JSON. Implies dry run")
+	addNetworkFlags(flags)
+	flags.Int64Var(&options.MaxStorage, "max-storage", -1, "Data usage limit in MB, -1 for no limit")
+	flags.Int64VarP(&options.MaxSize, "max-size", "z", -1, "Max size of media file in KB, -1 for no limit")
+	flags.StringVar(&options.Folder, "folder", "", "Target folder name")
+	flags.StringVar(&folderQuota, "folder-quota", "",
+		"Stop when total size of target folder, including existing files, would exceed this. eg: 5G")
+	flags.BoolVar(&options.Mirror, "mirror", false,
+		"After run, remove files in folder whose posts were not downloaded or kept in this run. "+
+			"Nothing is removed if run stops before the end of listing or --max-files (eg: due to --max-storage)")
+	flags.BoolVar(&options.MirrorDryRun, "mirror-dry-run", false,
+		"Only print files which --mirror would remove")
+	flags.IntVar(&options.MirrorMaxDelete, "mirror-max-delete", 50,
+		"Don't remove anything if --mirror would remove more than this many files, -1 for no limit")
+	flags.StringVar(&minFreeSpace, "min-free-space", "",
+		"Stop when free space on target filesystem would fall below this. eg: 1G")
+	flags.StringVar(&retention, "retention", "",
+		"After run, remove downloaded files except newest N (newest:N), younger than D days (days:D) "+
+			"or highest scoring N (top:N, needs --write-metadata)")
+
+	flags.BoolVar(&options.WriteMetadata, "write-metadata", false,
+		"Write post data and download info to <file>.json next to each file")
+	flags.BoolVar(&options.WriteManifest, "write-manifest", false,
+		"Record SHA-256 of saved files in "+manifestFileName+" in target folder, to check them with 'rrip verify'")
+	flags.BoolVar(&options.EmbedMetadata, "embed-metadata", false,
+		"Embed title, author, subreddit, permalink and date into JPEG / PNG / GIF files, "+
+			"and set file modification time to post date")
+	flags.IntVar(&options.ThumbnailSize, "thumbnails", 0,
+		"Write thumbnails fitting in SIZExSIZE pixels to .thumbs folder, 0 to disable")
+	flags.StringVar(&resizeMax, "resize-max", "",
+		"Downscale JPEG / PNG images to fit in WxH pixels, eg: 1920x1080")
+	flags.IntVar(&options.JPEGQuality, "jpeg-quality", 0,
+		"Recompress JPEG images with given quality (1-100)")
+	flags.BoolVar(&options.PNGToJPEG, "png-to-jpeg", false,
+		"Convert PNG images without transparency to JPEG")
+	flags.StringVar(&options.Dedupe, "dedupe", "off",
+		"What to do with files whose content was already downloaded: off|delete|hardlink|symlink. "+
+			"Deleted files aren't downloaded again in later runs")
+	flags.StringVar(&hashIndexPath, "dedupe-index", defaultIndexPath("hashes"),
+		"File storing SHA-256 hashes of downloaded files, shared across folders")
+	flags.IntVar(&options.PHashThreshold, "phash-threshold", -1,
+		"Skip images whose preview is within given Hamming distance (0-64) "+
+			"of an already downloaded image, -1 to disable. 6 is a good start")
+	flags.StringVar(&phashIndexPath, "phash-index", defaultIndexPath("phashes"),
+		"File storing perceptual hashes of downloaded files, shared across folders")
+	flags.StringVar(&limitRate, "limit-rate", "",
+		"Limit download speed in bytes per second, eg: 500K, 2M")
+	flags.StringVar(&limitRateSchedule, "limit-rate-schedule", "",
+		"Download speed limits for times of day, eg: \"08:00-18:00=200K,18:00-23:00=1M\". "+
+			"--limit-rate applies outside these windows")
+	flags.IntVar(&limitRateShare, "limit-rate-share", 1,
+		"Use 1/N of --limit-rate and --limit-rate-schedule rates, since N processes share them. "+
+			"Set by rrip run for concurrent jobs")
+	flags.StringVar(&options.StatsFile, "stats-json", "",
+		"Write stats of the run to given file as JSON")
+	flags.BoolVar(&options.WriteGallery, "write-gallery", false,
+		"Write an HTML gallery (index.html) of the folder at the end of run")
+	flags.StringVarP(&dataOutputFileName, "data-output-file", "O", "", "Log media links to given file")
+	flags.StringVarP(&dataOutputFormat, "data-output-format", "f", defaultDataOutputFormat, "Template for saving post data")
+	flags.StringVar(&options.DataOutputType, "data-output-type", "template",
+		"Format of data output file: template|jsonl|csv")
+	flags.StringSliceVar(&options.DataOutputFields, "data-output-fields", nil,
+		"Comma separated post data fields to write as CSV columns, eg: score,final_url,title")
+	flags.BoolVar(&dataOutputAppend, "data-output-append", false,
+		"Append to data output file instead of overwriting it")
+
+	flags.StringVar(&options.OgType, "og-type", "", "Look Up for a media link in page's og:property"+
 		" if link itself is not image/video (experimental). supported values: video, image, any")
-	flag.StringVar(&options.Sort, "sort", "", "Sort: best|hot|new|rising|top-<all|year|month|week|day>")
-	flag.IntVar(&options.MaxFiles, "max-files", -1, "Max number of files to download (+ve), -1 for no limit")
-	flag.IntVar(&options.MinScore, "min-score", 0, "Minimum score of the post to download")
-	flag.IntVar(&options.EntriesLimit, "entries-limit", 100, "Number of entries to fetch in one API request (devel)")
-
-	flag.StringVar(&titleContains, "title-contains", "", "Download if "+
-		"title contains substring matching given regex")
-	flag.StringVar(&flairContains, "flair-contains", "", "Download if "+
-		"flair contains substring matching given regex (works only if flair is plaintext)")
-	flag.StringVar(&linkContains, "link-contains", "", "Download if "+
-		"posted link contains substring matching given regex")
-
-	flag.StringVar(&titleNotContains, "title-not-contains", "", "Download if "+
-		"title does not contain substring matching given regex")
-	flag.StringVar(&flairNotContains, "flair-not-contains", "", "Download if "+
-		"flair does not contain substring matching given regex")
-	flag.StringVar(&linkNotContains, "link-not-contains", "", "Download if "+
-		"posted link does not contain substring matching given regex")
-
-	flag.StringVar(&options.Search, "search", "", "Search for given term")
-	flag.BoolVar(&options.PreferPreview, "prefer-preview", false,
+	flags.BoolVar(&options.PreferPreview, "prefer-preview", false,
 		"Prefer reddit preview image when possible")
-	flag.BoolVar(&options.DownloadPreview, "download-preview", false,
+	flags.BoolVar(&options.DownloadPreview, "download-preview", false,
 		"download reddit preview image instead of posted URL")
-	flag.IntVar(&options.PreviewRes, "preview-res", -1,
+	flags.IntVar(&options.PreviewRes, "preview-res", -1,
 		"Width of preview to download, eg: 640, 960, 1080")
 
-	flag.Parse()
-	args := flag.Args()
-	if (len(args) != 1 && options.Search == "") || help {
-		eprintf("Usage: %s <options> <r/subreddit>\n", os.Args[0])
-		flag.PrintDefaults()
+	flags.Usage = func() {
+		eprintf(usage, os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if help {
+		flags.Usage()
 		os.Exit(1)
 	}
+	path := listingPath(flags, config.apply(flags))
 
-	if options.UseHTTP1 {
-		client = http.Client{
-			Transport: &http.Transport{
-				TLSNextProto: map[string]func(authority string, c *tls.Conn) http.RoundTripper{},
-			},
-		}
-	} else {
-		client = http.Client{}
+	setupClients()
+
+	if !dataOutputTypes[options.DataOutputType] {
+		fatal("Supported values for --data-output-type are template, jsonl and csv")
 	}
 
-	if dataOutputFileName != "" && dataOutputFormat == "" {
+	if dataOutputFileName != "" && options.DataOutputType == "template" &&
+		dataOutputFormat == "" {
 		fmt.Fprintln(os.Stderr, "Data output format not provided. "+
 			"It must be a valid go template.")
 		os.Exit(1)
 	}
 
-	options.DataOutputFile = createLinksFile(dataOutputFileName)
-	if options.DataOutputFile != nil {
-		defer options.DataOutputFile.Close()
+	if (options.DataOutputType == "csv") != (len(options.DataOutputFields) > 0) {
+		fatal("--data-output-fields should be used with --data-output-type=csv")
 	}
 
-	var path string = ""
-
-	if len(args) > 0 {
-		// TODO: Join multireddits
-		path = strings.TrimSuffix(args[0], "/")
+	var hasContent bool
+	options.DataOutputFile, hasContent = createLinksFile(dataOutputFileName, dataOutputAppend)
+	if options.DataOutputFile != nil {
+		defer options.DataOutputFile.Close()
+		if options.DataOutputType == "csv" && !hasContent {
+			writeCSVRecord(options.DataOutputFields)
+		}
 	}
 
 	// validate some arguments
 	toCheck := map[string]int64{
-		"--max":         int64(options.MaxFiles),
 		"--max-storage": options.MaxStorage,
 		"--max-size":    options.MaxSize,
 	}
@@ -631,10 +1055,76 @@ func main() {
 		}
 	}
 
+	if resizeMax != "" {
+		_, err := fmt.Sscanf(resizeMax, "%dx%d", &options.ResizeWidth, &options.ResizeHeight)
+		if err != nil || options.ResizeWidth < 1 || options.ResizeHeight < 1 {
+			fatal("--resize-max should be of the form WxH, eg: 1920x1080")
+		}
+	}
+
+	if options.JPEGQuality < 0 || options.JPEGQuality > 100 {
+		fatal("--jpeg-quality should be between 1 and 100")
+	}
+
+	if !dedupeModes[options.Dedupe] {
+		fatal("Supported values for --dedupe are off, delete, hardlink and symlink")
+	}
+
+	options.FolderQuota = -1
+	if folderQuota != "" {
+		options.FolderQuota, err = parseSize(folderQuota)
+		check(err, "Invalid value for --folder-quota")
+	}
+
+	options.MinFreeSpace = -1
+	if minFreeSpace != "" {
+		options.MinFreeSpace, err = parseSize(minFreeSpace)
+		check(err, "Invalid value for --min-free-space")
+	}
+
+	if retention != "" {
+		policy, err := parseRetention(retention)
+		check(err, "Invalid value for --retention")
+		options.Retention = &policy
+	}
+
+	if limitRateShare < 1 {
+		fatal("Invalid value for option --limit-rate-share")
+	}
+	if limitRate != "" || limitRateSchedule != "" {
+		var rate int64
+		var schedule []rateWindow
+		if limitRate != "" {
+			rate, err = parseSize(limitRate)
+			check(err, "Invalid value for --limit-rate")
+		}
+		if limitRateSchedule != "" {
+			schedule, err = parseRateSchedule(limitRateSchedule)
+			check(err, "Invalid value for --limit-rate-schedule")
+		}
+		rateLimiter = NewRateLimiter(rate, schedule)
+		if limitRateShare > 1 {
+			rateLimiter = rateLimiter.Share(limitRateShare)
+		}
+	}
+
+	listing.apply(options.DataOutputFields, dataOutputFormat, fileNames.format)
+
+	if options.PHashThreshold < -1 || options.PHashThreshold > 64 {
+		fatal("--phash-threshold should be between 0 and 64, or -1")
+	}
+
+	if options.ThumbnailSize < 0 {
+		fatal("Invalid value for option --thumbnails")
+	}
+
 	if options.DryRun {
-		if options.MaxSize != -1 || options.MaxStorage != -1 {
+		if flags.Changed("dry-run") &&
+			(flags.Changed("max-size") || flags.Changed("max-storage")) {
 			fatal("Can't combine image-size based options with dry run")
 		}
+		// set from config file or environment, not applicable to dry run
+		options.MaxSize, options.MaxStorage = -1, -1
 	}
 
 	if options.PreviewRes > 0 && !options.DownloadPreview &&
@@ -647,6 +1137,8 @@ func main() {
 		fatal("Use only one of --prefer-preview and --download-preview")
 	}
 
+	fileNames.apply(flags)
+
 	og := options.OgType
 	if og != "" && og != "video" && og != "image" && og != "any" {
 		fatal("Only supported values for --og-type are image, video and any")
@@ -655,13 +1147,21 @@ func main() {
 	// if PrintPostData is enabled, enable dry run
 	options.DryRun = options.DryRun || options.PrintPostData
 
-	// enable debug output in case of dry run w/o print post data
-	options.Debug = options.Debug || (options.DryRun && !options.PrintPostData)
+	if options.MirrorDryRun && !options.Mirror {
+		fatal("--mirror-dry-run should be used with --mirror")
+	}
+
+	if options.MirrorMaxDelete < -1 {
+		fatal("Invalid value for option --mirror-max-delete")
+	}
 
-	if options.After != "" && !strings.HasPrefix(options.After, "t3_") {
-		options.After = "t3_" + options.After
+	if options.EstimateSize && !options.DryRun {
+		fatal("--estimate-size can only be used with dry run")
 	}
 
+	// enable debug output in case of dry run w/o print post data
+	options.Debug = options.Debug || (options.DryRun && !options.PrintPostData)
+
 	// compute actual MaxStorage in bytes
 	if options.MaxStorage != -1 {
 		options.MaxStorage *= 1000 * 1000 // MB
@@ -671,38 +1171,18 @@ func main() {
 		options.MaxSize *= 1000 // KB
 	}
 
-	regexVals := []struct {
-		re  **regexp.Regexp
-		opt string
-	}{
-		{&options.TitleContains, titleContains},
-		{&options.TitleNotContains, titleNotContains},
-		{&options.FlairContains, flairContains},
-		{&options.FlairNotContains, flairNotContains},
-		{&options.LinkContains, linkContains},
-		{&options.LinkNotContains, linkNotContains},
-	}
-
-	for _, rv := range regexVals {
-		if rv.opt != "" {
-			*(rv.re) = regexp.MustCompile(rv.opt)
-		}
+	if dataOutputFormat != "" {
+		options.DataOutputFormat = createTemplate("data-output-format", dataOutputFormat)
 	}
 
-	templateVals := []struct {
-		name string
-		tm   **template.Template
-		opt  string
-	}{
-		{"data-output-format", &options.DataOutputFormat, dataOutputFormat},
-		{"template-filter", &options.TemplateFilter, templateFilter},
-		{"filename-format", &options.FilenameFormat, filenameFormat},
+	if options.Dedupe != "off" && !options.DryRun {
+		hashIndex, err = loadHashIndex(hashIndexPath)
+		check(err, "Cannot load hash index")
 	}
 
-	for _, tv := range templateVals {
-		if tv.opt != "" {
-			*(tv.tm) = createTemplate(tv.name, tv.opt)
-		}
+	if options.PHashThreshold != -1 && !options.DryRun {
+		phashIndex, err = loadPHashIndex(phashIndexPath)
+		check(err, "Cannot load perceptual hash index")
 	}
 
 	// Create folder
@@ -722,6 +1202,17 @@ func main() {
 	// if dry run, change to folder only if folder already existed
 	if err == nil || !options.DryRun {
 		check(os.Chdir(options.Folder))
+		inTargetFolder = true
+	}
+
+	if options.WriteManifest && !options.DryRun {
+		manifestFile, err = openManifest(".")
+		check(err, "Cannot open manifest")
+	}
+
+	if options.FolderQuota != -1 && (err == nil || !options.DryRun) {
+		folderBytes, err = folderSize(".")
+		check(err, "Cannot compute size of folder")
 	}
 
 	// to properly handle Ctrl+C, notify os.Interrupt
@@ -742,7 +1233,9 @@ func main() {
 			eprintf("Removing possibly incomplete file: '%s'\n", downloadingFilename)
 			os.Remove(downloadingFilename)
 		}
+		stats.StopReason = "Interrupted"
 		PrintStat()
+		writeStatsFile()
 	case <-completion:
 		os.Exit(0)
 	}
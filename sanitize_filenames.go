@@ -1,8 +1,14 @@
 package main
 
 import (
+	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	flag "github.com/spf13/pflag"
+	"golang.org/x/text/unicode/norm"
 )
 
 var windowsSubst = map[rune]string{
@@ -22,6 +28,20 @@ var minimalSubst = map[rune]string{
 	'\\': "",
 }
 
+// Finder displays ':' as '/', and '/' is the path separator.
+var macSubst = map[rune]string{
+	'/':  "",
+	'\\': "",
+	':':  "-",
+}
+
+var portableSubst = map[rune]string{
+	' ': "_",
+	'/': "",
+	'|': "-",
+	':': "-",
+}
+
 var winBan = map[string]bool{
 	"CON": true, "PRN": true, "AUX": true, "NUL": true,
 	"COM1": true, "COM2": true, "COM3": true, "COM4": true,
@@ -42,26 +62,230 @@ func sanitizeWindowsFilename(name string) string {
 	return name
 }
 
-func sanitizeFileName(filename string, allowSpecialChars bool) string {
-	var b strings.Builder
-	var banned map[rune]string
-	if allowSpecialChars {
-		banned = minimalSubst
-	} else {
-		banned = windowsSubst
+func isASCIIPrint(r rune) bool {
+	return r < utf8.RuneSelf && strconv.IsPrint(r)
+}
+
+func isPortable(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+		r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-'
+}
+
+// A sanitizeProfile describes how file names are made safe for a
+// particular (set of) filesystems.
+type sanitizeProfile struct {
+	// applied to whole name before substitutions, may be nil
+	normalize func(string) string
+	subst     map[rune]string
+	// runes not in subst are kept if allowed, else replaced with badRune
+	allowed func(rune) bool
+	badRune string
+	// applied to whole name after substitutions, may be nil
+	finish func(string) string
+}
+
+var sanitizeProfiles = map[string]sanitizeProfile{
+	"posix": {
+		subst: minimalSubst, allowed: strconv.IsPrint, badRune: "-",
+	},
+	"windows": {
+		subst: windowsSubst, allowed: strconv.IsPrint, badRune: "-",
+		finish: sanitizeWindowsFilename,
+	},
+	"macos": {
+		normalize: norm.NFD.String,
+		subst:     macSubst, allowed: strconv.IsPrint, badRune: "-",
+	},
+	"ascii": {
+		normalize: transliterate,
+		subst:     windowsSubst, allowed: isASCIIPrint, badRune: "_",
+		finish: sanitizeWindowsFilename,
+	},
+	"portable": {
+		normalize: transliterate,
+		subst:     portableSubst, allowed: isPortable, badRune: "",
+		finish: sanitizeWindowsFilename,
+	},
+}
+
+// sanitizeChars applies the character substitutions of selected profile,
+// but not the rules which depend on the complete name (eg: reserved names).
+// Custom replacements are applied first, so that they match characters as
+// written by user, before normalization (eg: NFD for macos) changes them.
+// Replacements can contain anything, so they're sanitized too.
+func sanitizeChars(filename string) string {
+	profile := sanitizeProfiles[options.Sanitize]
+	if len(options.SanitizeReplace) > 0 {
+		var b strings.Builder
+		for _, r := range filename {
+			if repl, ok := options.SanitizeReplace[r]; ok {
+				b.WriteString(repl)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+		filename = b.String()
 	}
+	if profile.normalize != nil {
+		filename = profile.normalize(filename)
+	}
+	var b strings.Builder
 	for _, r := range filename {
-		repl, spec := banned[r]
-		if spec {
-			b.WriteString(repl)
-		} else if !strconv.IsPrint(r) {
-			b.WriteRune('-')
-		} else {
-			b.WriteRune(r)
+		b.WriteString(sanitizeRune(profile, r))
+	}
+	return b.String()
+}
+
+func sanitizeRune(profile sanitizeProfile, r rune) string {
+	if repl, spec := profile.subst[r]; spec {
+		return repl
+	}
+	if !profile.allowed(r) {
+		return profile.badRune
+	}
+	return string(r)
+}
+
+func sanitizeFileName(filename string) string {
+	name := sanitizeChars(filename)
+	if finish := sanitizeProfiles[options.Sanitize].finish; finish != nil {
+		return finish(name)
+	}
+	return name
+}
+
+// parseSanitizeReplace converts --sanitize-replace values to a rune map.
+func parseSanitizeReplace(replace map[string]string) map[rune]string {
+	result := map[rune]string{}
+	for from, to := range replace {
+		if utf8.RuneCountInString(from) != 1 {
+			fatal("--sanitize-replace: can only replace single characters, got " + quote(from))
+		}
+		r, _ := utf8.DecodeRuneInString(from)
+		result[r] = to
+	}
+	return result
+}
+
+// matches the " [id].ext" suffix added to every file name
+var idSuffixRegex = regexp.MustCompile(`\[([0-9a-z]+)\]\.[0-9A-Za-z]+$`)
+
+// idFromFileName returns post ID from the suffix of file name,
+// or empty string if there's no such suffix.
+func idFromFileName(name string) string {
+	match := idSuffixRegex.FindStringSubmatch(name)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// Post ID and extension alone take up to ~20 bytes, leave some room for title
+const minFilenameBytes = 32
+
+const truncationMark = ".."
+
+// truncateUTF8 returns longest prefix of s which fits in maxBytes bytes
+// without splitting a multi-byte character.
+func truncateUTF8(s string, maxBytes int) string {
+	if maxBytes <= 0 {
+		return ""
+	}
+	if len(s) <= maxBytes {
+		return s
+	}
+	end := maxBytes
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end]
+}
+
+// truncateFileName shortens the part of name before suffix (" [id].ext")
+// so that complete name fits in maxBytes. Suffix is always preserved,
+// since it's used to detect already downloaded files.
+func truncateFileName(name, suffix string, maxBytes int) string {
+	if maxBytes == -1 || len(name) <= maxBytes {
+		return name
+	}
+	if !strings.HasSuffix(name, suffix) {
+		return truncateUTF8(name, maxBytes)
+	}
+	stem := strings.TrimSuffix(name, suffix)
+	stem = truncateUTF8(stem, maxBytes-len(suffix)-len(truncationMark))
+	return stem + truncationMark + suffix
+}
+
+// Bytes left out of --max-filename-bytes, so that names of sidecar and
+// thumbnail (whose extension is shorter) fit too. This doesn't depend on
+// options, since that would change names of files already downloaded.
+const reservedFileNameBytes = len(sidecarExt)
+
+// postFileName returns sanitized file name for a post, given its name
+// formatted by --filename-format.
+func postFileName(formatted, id, extension string) string {
+	// Only the formatted part is sanitized, since idFromFileName needs the
+	// brackets around ID. The separating space follows the profile though
+	// (eg: "_" for portable), idSuffixRegex doesn't depend on it.
+	profile := sanitizeProfiles[options.Sanitize]
+	suffix := fmt.Sprintf("%s[%s]%s", sanitizeRune(profile, ' '), id, extension)
+	filename := sanitizeChars(formatted) + suffix
+	if profile.finish != nil {
+		filename = profile.finish(filename)
+	}
+	maxFilenameBytes := options.MaxFilenameBytes
+	if maxFilenameBytes != -1 {
+		maxFilenameBytes -= reservedFileNameBytes
+	}
+	return truncateFileName(filename, suffix, maxFilenameBytes)
+}
+
+// fileNameFlags holds options which decide names of downloaded files.
+// These are shared by the main command and `rrip rename`.
+type fileNameFlags struct {
+	format            string
+	allowSpecialChars bool
+	sanitizeReplace   map[string]string
+}
+
+func addFileNameFlags(flags *flag.FlagSet) *fileNameFlags {
+	f := &fileNameFlags{}
+	flags.StringVarP(&f.format, "filename-format", "t", defaultFileNameFormat,
+		"Template for naming files. (Post ID is always appended)")
+	flags.BoolVar(&f.allowSpecialChars, "allow-special-chars", false,
+		"Allow all characters in filenames except / and \\, "+
+			"And windows-special filenames like NUL. Same as --sanitize=posix")
+	flags.StringVar(&options.Sanitize, "sanitize", "windows",
+		"File name sanitization profile: posix|windows|macos|ascii|portable")
+	flags.StringToStringVar(&f.sanitizeReplace, "sanitize-replace", nil,
+		"Custom character replacements in file names, eg: '&=and,#='")
+	flags.IntVar(&options.MaxFilenameBytes, "max-filename-bytes", 255,
+		"Max length of file name in bytes, including ID, extension and room for .json "+
+			"suffix of metadata sidecar. -1 for no limit")
+	return f
+}
+
+// apply validates file name options after flags are parsed
+func (f *fileNameFlags) apply(flags *flag.FlagSet) {
+	if options.MaxFilenameBytes != -1 && options.MaxFilenameBytes < minFilenameBytes {
+		fatal(fmt.Sprintf("--max-filename-bytes should be at least %d", minFilenameBytes))
+	}
+
+	// either may be set from config file or environment too, in
+	// which case the one given on command line wins
+	if f.allowSpecialChars {
+		if flags.Changed("allow-special-chars") && flags.Changed("sanitize") {
+			fatal("Use only one of --allow-special-chars and --sanitize")
+		}
+		if !flags.Changed("sanitize") {
+			options.Sanitize = "posix"
 		}
 	}
-	if allowSpecialChars {
-		return b.String()
+
+	if _, ok := sanitizeProfiles[options.Sanitize]; !ok {
+		fatal("Supported values for --sanitize are posix, windows, macos, ascii and portable")
 	}
-	return sanitizeWindowsFilename(b.String())
+
+	options.SanitizeReplace = parseSanitizeReplace(f.sanitizeReplace)
+	options.FilenameFormat = createTemplate("filename-format", f.format)
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// setSanitizeOptions sets global file name options for a test, and
+// restores them when the test ends
+func setSanitizeOptions(t *testing.T, profile string, maxBytes int, replace map[rune]string) {
+	saved := options
+	t.Cleanup(func() { options = saved })
+	options.Sanitize = profile
+	options.MaxFilenameBytes = maxBytes
+	options.SanitizeReplace = replace
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	tests := []struct {
+		s        string
+		maxBytes int
+		want     string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 5, "hello"},
+		{"hello", 3, "hel"},
+		{"hello", 0, ""},
+		{"hello", -1, ""},
+		{"héllo", 2, "h"}, // é is 2 bytes, not split
+		{"héllo", 3, "hé"},
+		{"日本語", 4, "日"},
+		{"日本語", 6, "日本"},
+		{"🙂🙂", 5, "🙂"},
+	}
+	for _, tt := range tests {
+		if got := truncateUTF8(tt.s, tt.maxBytes); got != tt.want {
+			t.Errorf("truncateUTF8(%q, %d) = %q, want %q", tt.s, tt.maxBytes, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateFileName(t *testing.T) {
+	tests := []struct {
+		name, suffix string
+		maxBytes     int
+		want         string
+	}{
+		{"short [abc].jpg", " [abc].jpg", 255, "short [abc].jpg"},
+		{"short [abc].jpg", " [abc].jpg", -1, "short [abc].jpg"},
+		{"a long title [abc].jpg", " [abc].jpg", 18, "a long.. [abc].jpg"},
+		{"日本語のタイトル [abc].jpg", " [abc].jpg", 22, "日本語.. [abc].jpg"},
+		{"no suffix here", " [abc].jpg", 5, "no su"},
+	}
+	for _, tt := range tests {
+		got := truncateFileName(tt.name, tt.suffix, tt.maxBytes)
+		if got != tt.want {
+			t.Errorf("truncateFileName(%q, %q, %d) = %q, want %q",
+				tt.name, tt.suffix, tt.maxBytes, got, tt.want)
+		}
+		if tt.maxBytes != -1 && len(got) > tt.maxBytes {
+			t.Errorf("truncateFileName(%q) is %d bytes, more than %d", tt.name, len(got), tt.maxBytes)
+		}
+	}
+}
+
+func TestPostFileNameKeepsID(t *testing.T) {
+	long := strings.Repeat("Ünïcödé títlé ", 40)
+	titles := []string{
+		"Sunset over the lake",
+		"[OC] Mountains [4000x3000]",
+		"What? A *great* <view> | 10/10: \"wow\"",
+		"CON",
+		"   ",
+		"",
+		"Ελληνικά και Кириллица",
+		long,
+	}
+	for _, profile := range []string{"posix", "windows", "macos", "ascii", "portable"} {
+		for _, maxBytes := range []int{-1, 255, 64, minFilenameBytes} {
+			setSanitizeOptions(t, profile, maxBytes, map[rune]string{'[': "(", ' ': "_"})
+			for _, title := range titles {
+				name := postFileName(title, "abc123", ".jpg")
+				if id := idFromFileName(name); id != "abc123" {
+					t.Errorf("%s/%d: ID not found in %q (title %q)", profile, maxBytes, name, title)
+				}
+				if !strings.HasSuffix(name, ".jpg") {
+					t.Errorf("%s/%d: extension lost in %q", profile, maxBytes, name)
+				}
+				if !utf8.ValidString(name) {
+					t.Errorf("%s/%d: invalid UTF-8 in %q", profile, maxBytes, name)
+				}
+				if maxBytes != -1 && len(name)+reservedFileNameBytes > maxBytes {
+					t.Errorf("%s/%d: %q leaves no room for sidecar", profile, maxBytes, name)
+				}
+				if strings.ContainsAny(name, "/\\") {
+					t.Errorf("%s/%d: path separator in %q", profile, maxBytes, name)
+				}
+			}
+		}
+	}
+}
+
+func TestPostFileNameProfiles(t *testing.T) {
+	tests := []struct {
+		profile, title, want string
+	}{
+		{"posix", "a/b: c?", "ab: c? [x1].png"},
+		{"windows", "a/b: c?", "ab- c [x1].png"},
+		{"windows", "NUL", "NUL [x1].png"},
+		{"ascii", "Café – ok", "Cafe - ok [x1].png"},
+		{"portable", "Café [OC] 1/2", "Cafe_OC_12_[x1].png"},
+		{"macos", "a:b", "a-b [x1].png"},
+	}
+	for _, tt := range tests {
+		setSanitizeOptions(t, tt.profile, 255, nil)
+		if got := postFileName(tt.title, "x1", ".png"); got != tt.want {
+			t.Errorf("%s: postFileName(%q) = %q, want %q", tt.profile, tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeReplaceBeforeNormalize(t *testing.T) {
+	// replacement keys are written precomposed, and must match
+	// before macos profile decomposes them
+	setSanitizeOptions(t, "macos", 255, map[rune]string{'\u00e9': "e", '&': "and"})
+	if got, want := sanitizeChars("Caf\u00e9 & Cr\u00e8me"), "Cafe and Cre\u0300me"; got != want {
+		t.Errorf("sanitizeChars = %q, want %q", got, want)
+	}
+	// replacement is sanitized too
+	setSanitizeOptions(t, "windows", 255, map[rune]string{'&': "a/b?"})
+	if got := sanitizeChars("x&y"); got != "xaby" {
+		t.Errorf("sanitizeChars = %q, want %q", got, "xaby")
+	}
+}
+
+func TestIdFromFileName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"title [abc123].jpg", "abc123"},
+		{"title_[abc123].jpg", "abc123"},
+		{"[abc123].mp4", "abc123"},
+		{"a [b] c [xyz].png", "xyz"},
+		{"title abc123.jpg", ""},
+		{"title [abc123].jpg.json", ""},
+		{"title [ABC].jpg", ""},
+	}
+	for _, tt := range tests {
+		if got := idFromFileName(tt.name); got != tt.want {
+			t.Errorf("idFromFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
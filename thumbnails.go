@@ -0,0 +1,143 @@
+// functions to generate thumbnails of downloaded files
+
+package main
+
+import (
+	"errors"
+	"html"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	thumbsFolder     = ".thumbs"
+	thumbnailExt     = ".jpg"
+	thumbnailQuality = 85
+	// don't try to decode images larger than this, to avoid using
+	// gigabytes of memory on a malicious / broken file
+	maxDecodePixels = 100 * 1000 * 1000
+)
+
+// thumbnailName returns path of thumbnail of filename, which is
+// in thumbsFolder next to the file
+func thumbnailName(filename string) string {
+	dir, name := filepath.Split(filename)
+	return filepath.Join(dir, thumbsFolder, name+thumbnailExt)
+}
+
+func isStillImage(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	}
+	return false
+}
+
+// decodeImage decodes JPEG, PNG or GIF (first frame) from r
+func decodeImage(r io.ReadSeeker) (image.Image, string, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if config.Width*config.Height > maxDecodePixels {
+		return nil, "", errors.New("image too large to decode")
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	return image.Decode(r)
+}
+
+func decodeImageFile(filename string) (image.Image, string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+	return decodeImage(file)
+}
+
+// resizeToFit scales img down to fit within maxWidth x maxHeight,
+// preserving aspect ratio. Images which already fit are returned as is.
+func resizeToFit(img image.Image, maxWidth, maxHeight int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxWidth && height <= maxHeight {
+		return img
+	}
+	scale := float64(maxWidth) / float64(width)
+	if s := float64(maxHeight) / float64(height); s < scale {
+		scale = s
+	}
+	newWidth := max(1, int(float64(width)*scale+0.5))
+	newHeight := max(1, int(float64(height)*scale+0.5))
+	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
+
+func writeThumbnail(filename string, img image.Image) error {
+	img = resizeToFit(img, options.ThumbnailSize, options.ThumbnailSize)
+	name := thumbnailName(filename)
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(name, func(w io.Writer) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: thumbnailQuality})
+	})
+}
+
+// thumbnailPreview returns the smallest reddit preview which is
+// at least as wide as thumbnail size, or nil if post has no preview
+func thumbnailPreview(post PostData) *ImagePreviewEntry {
+	if len(post.Preview.Images) == 0 {
+		return nil
+	}
+	choices := post.Preview.Images[0]
+	for _, preview := range choices.Resolutions {
+		if preview.Width >= options.ThumbnailSize {
+			return pickPreview(choices, preview.Width)
+		}
+	}
+	return pickPreview(choices, -1)
+}
+
+// makeThumbnail writes thumbnail of a downloaded file into .thumbs folder.
+// Still images are decoded from the file itself, for videos the reddit
+// preview image is fetched instead. Videos without preview are skipped.
+func makeThumbnail(filename string, post PostData) error {
+	if isStillImage(filename) {
+		img, _, err := decodeImageFile(filename)
+		if err != nil {
+			return err
+		}
+		return writeThumbnail(filename, img)
+	}
+
+	preview := thumbnailPreview(post)
+	if preview == nil {
+		log("No preview for thumbnail:", quote(filename))
+		return nil
+	}
+	response, err := GetUrl(html.UnescapeString(preview.Url))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return errors.New("cannot fetch preview: " + response.Status)
+	}
+	img, _, err := image.Decode(response.Body)
+	if err != nil {
+		return err
+	}
+	return writeThumbnail(filename, img)
+}
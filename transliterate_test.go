@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestTransliterate(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"plain ASCII", "plain ASCII"},
+		{"Café crème brûlée", "Cafe creme brulee"},
+		{"Straße, Æsir, Łódź", "Strasse, AEsir, Lodz"},
+		{"“quoted” – dash…", "\"quoted\" - dash..."},
+		{"Ελλάδα", "Ellada"},
+		{"Москва", "Moskva"},
+		{"日本", "日本"}, // left for sanitize profile
+		{"é", "e"},  // decomposed input
+		{"́", ""},    // lone combining mark
+	}
+	for _, tt := range tests {
+		if got := transliterate(tt.s); got != tt.want {
+			t.Errorf("transliterate(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
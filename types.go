@@ -8,20 +8,50 @@ import (
 
 type Stats struct {
 	Processed, Saved, Failed, Repeated int
-	CopiedBytes                        int64
+	Duplicates, NearDuplicates         int
+	// bytes received from network, and bytes stored on disk after processing
+	CopiedBytes, StoredBytes int64
+	EstimatedBytes           int64 // sizes reported by HEAD in dry run
+	Pruned                   int   // files removed by retention policy
+	Mirrored                 int   // files removed by --mirror
+	StopReason               string
 }
 
 type Options struct {
 	After, Sort, UserAgent, Folder   string
 	EntriesLimit, MaxFiles, MinScore int
-	Debug, DryRun, AllowSpecialChars bool
+	Debug, DryRun, EstimateSize      bool
+	Sanitize                         string
+	SanitizeReplace                  map[rune]string
 	MaxStorage, MaxSize              int64
+	MaxFilenameBytes                 int
+	FolderQuota, MinFreeSpace        int64
+	Retention                        *RetentionPolicy
+	Mirror, MirrorDryRun             bool
+	WriteManifest                    bool
+	ListOnly                         bool
+	StatsFile                        string
+	MirrorMaxDelete                  int
 	OgType                           string
 	DataOutputFile                   io.WriteCloser
+	DataOutputType                   string
+	DataOutputFields                 []string
 	DataOutputFormat                 *template.Template
 	TemplateFilter                   *template.Template
 	FilenameFormat                   *template.Template
 	PrintPostData                    bool
+	WriteMetadata                    bool
+	EmbedMetadata                    bool
+	WriteGallery                     bool
+	ThumbnailSize                    int
+	ResizeWidth, ResizeHeight        int
+	JPEGQuality                      int
+	PNGToJPEG                        bool
+	Dedupe                           string
+	PHashThreshold                   int
+	MaxMeanLuminance, MinBlackRatio  float64
+	DominantColors                   []string
+	ColorStats                       bool // needed for filters or templates
 	TitleContains, TitleNotContains  *regexp.Regexp
 	FlairContains, FlairNotContains  *regexp.Regexp
 	LinkContains, LinkNotContains    *regexp.Regexp
@@ -30,6 +60,11 @@ type Options struct {
 	PreferPreview                    bool
 	PreviewRes                       int
 	UseHTTP1                         bool
+	ConnectTimeout, RequestTimeout   int
+	StallTimeout                     int
+	Proxy, CACert                    string
+	Insecure                         bool
+	Resolve                          []string
 }
 
 type ImagePreviewEntry struct {
@@ -48,6 +83,7 @@ type PostData struct {
 	Score                int
 	Subreddit, Author    string
 	LinkFlairText        string
+	Permalink            string
 	CreatedUtc           int64
 	Preview              struct {
 		Images []ImagePreview
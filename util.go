@@ -2,9 +2,12 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 func getTerminalSize() int {
@@ -33,6 +36,8 @@ func quote(s string) string {
 
 func fatal(val ...interface{}) {
 	fmt.Fprintln(os.Stderr, val...)
+	// partial stats are still useful to `rrip run`
+	writeStatsFile()
 	os.Exit(1)
 }
 
@@ -55,6 +60,20 @@ func max(a, b int) int {
 	return b
 }
 
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 func check(e error, extra ...interface{}) {
 	if e != nil {
 		fmt.Fprintln(os.Stderr, extra...)
@@ -84,3 +103,68 @@ func size(bytes int64) string {
 	}
 	return strconv.FormatInt(bytes, 10) + "B"
 }
+
+var mediaExtensions = map[string]bool{
+	".jpeg": true, ".jpg": true, ".png": true, ".gif": true, ".mp4": true,
+}
+
+// isMediaFile reports whether name looks like a file downloaded by rrip
+func isMediaFile(name string) bool {
+	return mediaExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+var sizeUnits = map[string]int64{
+	"": 1, "B": 1, "K": 1000, "KB": 1000, "M": 1000 * 1000, "MB": 1000 * 1000,
+	"G": 1000 * 1000 * 1000, "GB": 1000 * 1000 * 1000,
+}
+
+// parseSize parses sizes like 500K, 1.5M or 2G into bytes.
+// Units are decimal, same as in output of size()
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i == -1 {
+		i = len(s)
+	}
+	unit, ok := sizeUnits[strings.TrimSpace(s[i:])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in %q", s)
+	}
+	value, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(value * float64(unit)), nil
+}
+
+// createTemp creates a temporary file in folder of filename, to be renamed
+// over filename once written. Its name is short, since a name derived from
+// filename (eg: filename + ".tmp") may not fit in filesystem's limit.
+func createTemp(filename string) (*os.File, error) {
+	return os.CreateTemp(filepath.Dir(filename), ".rrip-*.tmp")
+}
+
+// writeFileAtomic writes filename using write through a temporary file,
+// so that an interrupt doesn't leave a truncated file behind.
+func writeFileAtomic(filename string, write func(io.Writer) error) error {
+	output, err := createTemp(filename)
+	if err != nil {
+		return err
+	}
+	err = write(output)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(output.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(output.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(output.Name())
+	}
+	return err
+}
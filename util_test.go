@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+	}{
+		{"0", 0},
+		{"500", 500},
+		{"500B", 500},
+		{"500K", 500 * 1000},
+		{"500kb", 500 * 1000},
+		{"1.5M", 1500 * 1000},
+		{"2G", 2 * 1000 * 1000 * 1000},
+		{" 10 MB ", 10 * 1000 * 1000},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.s)
+		if err != nil || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", tt.s, got, err, tt.want)
+		}
+	}
+	for _, s := range []string{"", "K", "10X", "-5M", "1..5M", "five"} {
+		if got, err := parseSize(s); err == nil {
+			t.Errorf("parseSize(%q) = %d, want error", s, got)
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	// name at the usual NAME_MAX, so that name + ".tmp" wouldn't fit
+	name := filepath.Join(dir, strings.Repeat("x", 250)+".json")
+	write := func(content string, err error) error {
+		return writeFileAtomic(name, func(w io.Writer) error {
+			io.WriteString(w, content)
+			return err
+		})
+	}
+	for _, content := range []string{"first", "second"} {
+		if err := write(content, nil); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := os.ReadFile(name); err != nil || string(b) != content {
+			t.Errorf("content = %q, %v, want %q", b, err, content)
+		}
+	}
+
+	// failed write leaves existing file as is
+	if err := write("partial", errors.New("failed")); err == nil {
+		t.Error("error of write not returned")
+	}
+	if b, _ := os.ReadFile(name); string(b) != "second" {
+		t.Errorf("content = %q after failed write, want %q", b, "second")
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("temporary files left behind: %d files in folder", len(entries))
+	}
+}